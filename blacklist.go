@@ -1,6 +1,7 @@
 package blacklist
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -12,10 +13,31 @@ import (
 type (
 	//Blacklist is the main controller for rita-blacklist
 	Blacklist struct {
-		db           database.Handle
-		lists        []list.List
-		rpcs         map[list.BlacklistedEntryType][]rpc.RPC
-		errorHandler func(error)
+		db             database.Handle
+		lists          []list.List
+		rpcs           map[list.BlacklistedEntryType][]rpc.RPC
+		errorHandler   func(error)
+		incremental    bool
+		updateWorkers  int
+		checkBatchSize int
+		filterRate     float64
+		filterMutex    *sync.Mutex
+		filterCache    map[string]cachedFilters
+		metaMutex      *sync.Mutex
+		metaCache      *cachedMetadata
+		metaCacheTime  time.Duration
+		metaGeneration int64
+		updating       int
+	}
+
+	//CheckOptions alters how indexes are matched by CheckEntriesWithOptions
+	CheckOptions struct {
+		//SuffixMatch causes hostname checks to return the entries of every
+		//blacklisted parent domain in addition to exact matches. Lists with
+		//SuffixMatch set in their Metadata are always checked this way.
+		//The Index of a result found through a parent domain holds the
+		//parent domain which matched.
+		SuffixMatch bool
 	}
 )
 
@@ -23,10 +45,16 @@ type (
 //backing database
 func NewBlacklist(db database.Handle, errorHandler func(error)) *Blacklist {
 	return &Blacklist{
-		db:           db,
-		lists:        make([]list.List, 0),
-		rpcs:         make(map[list.BlacklistedEntryType][]rpc.RPC),
-		errorHandler: errorHandler,
+		db:             db,
+		lists:          make([]list.List, 0),
+		rpcs:           make(map[list.BlacklistedEntryType][]rpc.RPC),
+		errorHandler:   errorHandler,
+		incremental:    true,
+		updateWorkers:  4,
+		checkBatchSize: 1000,
+		filterMutex:    new(sync.Mutex),
+		metaMutex:      new(sync.Mutex),
+		metaCacheTime:  10 * time.Second,
 	}
 }
 
@@ -37,64 +65,214 @@ func (b *Blacklist) SetLists(l ...list.List) {
 
 //SetRPCs takes in a remote procedure calls for checking the index of the
 //given entryType. This is meant for querying web services and outside programs.
-//These functions will be ran when CheckEntries is called.
+//These functions will be ran when CheckEntries is called. Use rpc.Chain to
+//add caching, rate limiting, retries or circuit breaking to an RPC.
 func (b *Blacklist) SetRPCs(rpcs ...rpc.RPC) {
 	for _, call := range rpcs {
 		b.rpcs[call.GetType()] = append(b.rpcs[call.GetType()], call)
 	}
 }
 
+//SetIncrementalUpdates sets whether existing lists are refreshed by applying
+//the entries added and removed since the last fetch (the default), or by
+//staging a complete copy of the list and swapping it in. Incremental updates
+//write less data, but hold the changes in memory until the fetch finishes.
+func (b *Blacklist) SetIncrementalUpdates(incremental bool) {
+	b.incremental = incremental
+}
+
+//SetUpdateWorkers sets the maximum number of lists which are fetched and
+//written to the database at the same time during Update. Defaults to 4.
+func (b *Blacklist) SetUpdateWorkers(workers int) {
+	b.updateWorkers = workers
+}
+
+//SetCheckBatchSize sets the maximum number of indexes CheckEntriesStream
+//looks up at once. Defaults to 1000.
+func (b *Blacklist) SetCheckBatchSize(size int) {
+	b.checkBatchSize = size
+}
+
+//GetRegisteredLists returns the metadata of the lists registered with
+//the blacklist database
+func (b *Blacklist) GetRegisteredLists(ctx context.Context) ([]list.Metadata, error) {
+	return b.db.GetRegisteredLists(ctx)
+}
+
+//updateOptions returns the settings which alter how lists are written
+func (b *Blacklist) updateOptions() updateOptions {
+	return updateOptions{
+		incremental: b.incremental,
+		filterRate:  b.filterRate,
+	}
+}
+
 //Update updates the blacklist database with the latest information pulled
-//from the registered sources
-func (b *Blacklist) Update() {
+//from the registered sources and returns a ListReport for each list
+func (b *Blacklist) Update() []ListReport {
+	return b.UpdateContext(context.Background())
+}
+
+//UpdateContext updates the blacklist database with the latest information
+//pulled from the registered sources and returns a ListReport for each list.
+//Lists removed from the database are reported first, followed by the
+//lists which were already registered and then the new lists. Cancelling
+//ctx stops in-flight fetches and database writes. Lists which were
+//interrupted keep their old entries.
+func (b *Blacklist) UpdateContext(ctx context.Context) []ListReport {
 	//handle errors
 	finishedProcessingErrors := make(chan struct{})
 	errorChannel := createErrorChannel(b.errorHandler, finishedProcessingErrors)
 	defer func() { <-finishedProcessingErrors }()
 	defer close(errorChannel)
 
+	//checks read the metadata from the database until the update finishes
+	b.invalidateMetadata(1)
+	defer b.invalidateMetadata(-1)
+
 	//get the existing lists from the db
-	remoteMetas, err := b.db.GetRegisteredLists()
+	remoteMetas, err := b.db.GetRegisteredLists(ctx)
 	if err != nil {
-		errorChannel <- err
-		return
+		errorChannel <- databaseError("", "", err)
+		return nil
 	}
 
-	//get the lists to remove from the db
-	metasToRemove := getListsToRemove(b.lists, remoteMetas)
-	for _, metaToRemove := range metasToRemove {
-		err = b.db.RemoveList(metaToRemove)
-		if err != nil {
-			errorChannel <- err
-			continue
-		}
+	//queue up the lists to remove from the db
+	var jobs []updateJob
+	for _, metaToRemove := range getListsToRemove(b.lists, remoteMetas) {
+		metaToRemove := metaToRemove
+		jobs = append(jobs, func(errorsOut chan<- error) ListReport {
+			return removeList(ctx, metaToRemove, b.db, errorsOut)
+		})
 	}
 
 	existingLists, listsToAdd := findExistingLists(b.lists, remoteMetas)
 
-	updateExistingLists(existingLists, b.db, errorChannel)
+	//queue up the lists which need to be fetched
+	for _, existingList := range existingLists {
+		existingList := existingList
+		if !list.ShouldFetch(existingList.GetMetadata()) {
+			jobs = append(jobs, skipList(existingList.GetMetadata()))
+			continue
+		}
+		jobs = append(jobs, func(errorsOut chan<- error) ListReport {
+			return updateExistingList(ctx, existingList, b.db, b.updateOptions(), errorsOut)
+		})
+	}
+	for _, listToAdd := range listsToAdd {
+		listToAdd := listToAdd
+		if !list.ShouldFetch(listToAdd.GetMetadata()) {
+			jobs = append(jobs, skipList(listToAdd.GetMetadata()))
+			continue
+		}
+		jobs = append(jobs, func(errorsOut chan<- error) ListReport {
+			return createNewList(ctx, listToAdd, b.db, b.updateOptions(), errorsOut)
+		})
+	}
 
-	createNewLists(listsToAdd, b.db, errorChannel)
+	return runUpdateJobs(jobs, b.updateWorkers, errorChannel)
 }
 
 //CheckEntries checks entries of different types against the blacklist database
 func (b *Blacklist) CheckEntries(entryType list.BlacklistedEntryType, indexes ...string) map[string][]database.BlacklistResult {
+	return b.CheckEntriesWithOptionsContext(context.Background(), CheckOptions{}, entryType, indexes...)
+}
+
+//CheckEntriesContext checks entries of different types against the
+//blacklist database. Cancelling ctx abandons the remaining lookups and
+//the results found so far are returned.
+func (b *Blacklist) CheckEntriesContext(ctx context.Context,
+	entryType list.BlacklistedEntryType, indexes ...string) map[string][]database.BlacklistResult {
+	return b.CheckEntriesWithOptionsContext(ctx, CheckOptions{}, entryType, indexes...)
+}
+
+//CheckEntriesWithOptions checks entries of different types against the
+//blacklist database using the given CheckOptions
+func (b *Blacklist) CheckEntriesWithOptions(opts CheckOptions,
+	entryType list.BlacklistedEntryType, indexes ...string) map[string][]database.BlacklistResult {
+	return b.CheckEntriesWithOptionsContext(context.Background(), opts, entryType, indexes...)
+}
+
+//CheckEntriesWithOptionsContext checks entries of different types against
+//the blacklist database using the given CheckOptions. Cancelling ctx
+//abandons the remaining lookups and the results found so far are returned.
+func (b *Blacklist) CheckEntriesWithOptionsContext(ctx context.Context, opts CheckOptions,
+	entryType list.BlacklistedEntryType, indexes ...string) map[string][]database.BlacklistResult {
+	return b.checkEntries(ctx, opts, entryType, indexes, b.errorHandler)
+}
+
+//CheckEntriesWithErrors checks entries like CheckEntriesWithOptionsContext,
+//but returns the errors which occurred instead of passing them to the
+//errorHandler. This allows callers to tell an index which is not
+//blacklisted apart from a lookup which failed.
+func (b *Blacklist) CheckEntriesWithErrors(ctx context.Context, opts CheckOptions,
+	entryType list.BlacklistedEntryType, indexes ...string) (map[string][]database.BlacklistResult, []error) {
+	var errs []error
+	results := b.checkEntries(ctx, opts, entryType, indexes, func(err error) {
+		errs = append(errs, err)
+	})
+	return results, errs
+}
+
+//checkEntries checks entries against the blacklist database and the RPCs,
+//passing any errors which occur to handleError
+func (b *Blacklist) checkEntries(ctx context.Context, opts CheckOptions,
+	entryType list.BlacklistedEntryType, indexes []string,
+	handleError func(error)) map[string][]database.BlacklistResult {
 	results := make(map[string][]database.BlacklistResult)
-	for _, index := range indexes {
-		//check against cached blacklists
-		entries, err := b.db.FindEntries(entryType, index)
+
+	//the registered lists are needed for bloom filters, netblocks
+	//and parent domains
+	remoteMetas, err := b.registeredLists(ctx)
+	if err != nil {
+		handleError(databaseError("", "", err))
+		return results
+	}
+	filters := b.loadFilters(ctx, remoteMetas, handleError)
+
+	//check against cached blacklists
+	normalized := make([]string, len(indexes))
+	for i, index := range indexes {
+		normalized[i] = list.NormalizeIndex(entryType, index)
+	}
+	found, err := b.findEntries(ctx, filters, entryType, normalized)
+	if err != nil {
+		handleError(list.WrapError(list.CategoryDatabase, "", entryType, "", err))
+		return results
+	}
+	for i, index := range indexes {
+		results[index] = found[normalized[i]]
+	}
+
+	//check ip addresses against the cached netblocks
+	if entryType == list.BlacklistedIPType && hasRegisteredType(remoteMetas, list.BlacklistedCIDRType) {
+		err = b.findContainingNetworks(ctx, filters, indexes, results, handleError)
 		if err != nil {
-			b.errorHandler(err)
-			continue
+			handleError(list.WrapError(list.CategoryDatabase, "", list.BlacklistedCIDRType, "", err))
+		}
+	}
+
+	//check hostnames against the cached parent domains
+	if entryType == list.BlacklistedHostnameType {
+		suffixLists := getSuffixMatchLists(remoteMetas, opts)
+		if len(suffixLists) > 0 {
+			err = b.findParentDomains(ctx, filters, indexes, suffixLists, results)
+			if err != nil {
+				handleError(list.WrapError(list.CategoryDatabase, "", entryType, "", err))
+			}
 		}
-		results[index] = entries
 	}
+
 	//run remote procedure calls
 	for _, rpc := range b.rpcs[entryType] {
+		if ctx.Err() != nil {
+			handleError(ctx.Err())
+			return results
+		}
 		//get the results from this check on all of the indexes
-		rpcResults, err := rpc.Check(indexes...)
+		rpcResults, err := rpc.Check(ctx, indexes...)
 		if err != nil {
-			b.errorHandler(err)
+			handleError(list.WrapError(list.CategoryRPC, "", entryType, "", err))
 			continue
 		}
 		//add the results to the overall results
@@ -105,6 +283,86 @@ func (b *Blacklist) CheckEntries(entryType list.BlacklistedEntryType, indexes ..
 	return results
 }
 
+//hasRegisteredType returns true if any of the given lists produces
+//entries of the given type
+func hasRegisteredType(remoteMetas []list.Metadata, entryType list.BlacklistedEntryType) bool {
+	for _, remoteMeta := range remoteMetas {
+		for _, metaType := range remoteMeta.Types {
+			if metaType == entryType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//getSuffixMatchLists returns the names of the lists whose hostname entries
+//should match subdomains during the current check
+func getSuffixMatchLists(remoteMetas []list.Metadata, opts CheckOptions) map[string]bool {
+	suffixLists := make(map[string]bool)
+	for _, remoteMeta := range remoteMetas {
+		if (opts.SuffixMatch || remoteMeta.SuffixMatch) &&
+			hasRegisteredType([]list.Metadata{remoteMeta}, list.BlacklistedHostnameType) {
+			suffixLists[remoteMeta.Name] = true
+		}
+	}
+	return suffixLists
+}
+
+//findParentDomains finds the cached parent domains of the given hostnames
+//which belong to the given lists and adds them to results
+func (b *Blacklist) findParentDomains(ctx context.Context, filters filterSet, hostnames []string,
+	suffixLists map[string]bool, results map[string][]database.BlacklistResult) error {
+	parents := make(map[string][]string, len(hostnames))
+	var candidates []string
+	for _, hostname := range hostnames {
+		parents[hostname] = list.ParentDomains(hostname)
+		candidates = append(candidates, parents[hostname]...)
+	}
+	found, err := b.findEntries(ctx, filters, list.BlacklistedHostnameType, candidates)
+	if err != nil {
+		return err
+	}
+	for _, hostname := range hostnames {
+		for _, parent := range parents[hostname] {
+			for _, entry := range found[parent] {
+				if suffixLists[entry.List] {
+					results[hostname] = append(results[hostname], entry)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//findContainingNetworks finds the cached netblocks containing the given
+//ip addresses and adds them to results. Invalid addresses are reported
+//to handleError and skipped.
+func (b *Blacklist) findContainingNetworks(ctx context.Context, filters filterSet, ips []string,
+	results map[string][]database.BlacklistResult, handleError func(error)) error {
+	networks := make(map[string][]string, len(ips))
+	var candidates []string
+	for _, ip := range ips {
+		ipNetworks, err := list.ContainingNetworks(ip)
+		if err != nil {
+			handleError(list.WrapError(list.CategoryValidate, "", list.BlacklistedIPType, ip, err))
+			continue
+		}
+		networks[ip] = ipNetworks
+		candidates = append(candidates, ipNetworks...)
+	}
+	found, err := b.findEntries(ctx, filters, list.BlacklistedCIDRType, candidates)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		for _, network := range networks[ip] {
+			results[ip] = append(results[ip], found[network]...)
+		}
+	}
+	return nil
+}
+
 func createErrorChannel(errHandler func(error), finished chan<- struct{}) chan<- error {
 	errorChannel := make(chan error)
 	go func(errHandler func(error), errors <-chan error, finished chan<- struct{}) {
@@ -154,6 +412,10 @@ func findExistingLists(loadedLists []list.List, remoteMetas []list.Metadata) ([]
 			// update the local metadata with fields stored in DB
 			localMeta := loadedList.GetMetadata()
 			localMeta.LastUpdate = foundMeta.LastUpdate
+			localMeta.FilterVersion = foundMeta.FilterVersion
+			localMeta.ETag = foundMeta.ETag
+			localMeta.LastModified = foundMeta.LastModified
+			localMeta.ContentHash = foundMeta.ContentHash
 			loadedList.SetMetadata(localMeta)
 
 			existingLists = append(existingLists, loadedList)
@@ -163,77 +425,3 @@ func findExistingLists(loadedLists []list.List, remoteMetas []list.Metadata) ([]
 	}
 	return existingLists, listsToAdd
 }
-
-func updateExistingLists(existingLists []list.List,
-	dbHandle database.Handle, errorsOut chan<- error) {
-	for _, existingList := range existingLists {
-		meta := existingList.GetMetadata()
-		if list.ShouldFetch(meta) {
-			//delete all existing entries and re-add the list
-			err := dbHandle.ClearCache(meta)
-
-			if err != nil {
-				errorsOut <- err
-				continue
-			}
-
-			//kick off fetching in a new thread
-			entryMap := list.FetchAndValidateEntries(existingList, errorsOut)
-
-			wg := new(sync.WaitGroup)
-			for entryType, entryChannel := range entryMap {
-				wg.Add(1)
-				go dbHandle.InsertEntries(entryType, entryChannel, wg, errorsOut)
-			}
-			//InsertEntries only finishes if FetchAndValidateEntries finishes
-			wg.Wait()
-
-			meta.LastUpdate = time.Now().Unix()
-			err = dbHandle.UpdateListMetadata(meta)
-			if err != nil {
-				errorsOut <- err
-				continue
-			}
-		}
-	}
-}
-
-func createNewLists(listsToAdd []list.List,
-	dbHandle database.Handle, errorsOut chan<- error) {
-	for _, listToAdd := range listsToAdd {
-		meta := listToAdd.GetMetadata()
-		if list.ShouldFetch(listToAdd.GetMetadata()) {
-			//register the list, create, and index the new collections
-			//set the cache to invalid so if the code errors,
-			//the code will reimport it
-			preWriteMetaCopy := meta
-			preWriteMetaCopy.LastUpdate = 0
-			preWriteMetaCopy.CacheTime = 0
-			err := dbHandle.RegisterList(preWriteMetaCopy)
-
-			if err != nil {
-				errorsOut <- err
-				continue
-			}
-
-			//kick off fetching in a new thread
-			entryMap := list.FetchAndValidateEntries(listToAdd, errorsOut)
-
-			wg := new(sync.WaitGroup)
-			for entryType, entryChannel := range entryMap {
-				wg.Add(1)
-				go dbHandle.InsertEntries(entryType, entryChannel, wg, errorsOut)
-			}
-			//InsertEntries only finishes if FetchAndValidateEntries finishes
-			wg.Wait()
-
-			//set the cache to valid
-			meta.LastUpdate = time.Now().Unix()
-			err = dbHandle.UpdateListMetadata(meta)
-			if err != nil {
-				errorsOut <- err
-				continue
-			}
-		}
-	}
-}
@@ -1,12 +1,20 @@
 package blacklist
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/activecm/mgosec"
 	"github.com/activecm/rita-bl/database"
 	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists"
 	"github.com/activecm/rita-bl/sources/mock"
 )
 
@@ -14,10 +22,7 @@ import (
 var __blacklistTestHandle *Blacklist
 
 func TestMain(m *testing.M) {
-	db, err := database.NewMongoDB("localhost:27017", mgosec.None, "rita-blacklist-TEST")
-	if err != nil {
-		os.Exit(-1)
-	}
+	db := database.NewMemoryDB()
 	__blacklistTestHandle = NewBlacklist(db, func(err error) { panic(err) })
 	os.Exit(m.Run())
 }
@@ -31,6 +36,7 @@ func TestDummyList(t *testing.T) {
 	t.Run("Update Existing", UpdateDummyList)
 	t.Run("IP Search", DummyIPSearch)
 	t.Run("Hostname Search", DummyHostnameSearch)
+	t.Run("Stream Search", DummyStreamSearch)
 	t.Run("Delete", DeleteDummyList)
 }
 
@@ -88,3 +94,705 @@ func TestGoogleRPC(t *testing.T) {
 		t.Fail()
 	}
 }*/
+
+func DummyStreamSearch(t *testing.T) {
+	__blacklistTestHandle.SetCheckBatchSize(2)
+	defer __blacklistTestHandle.SetCheckBatchSize(1000)
+
+	indexes := make(chan string)
+	go func() {
+		for _, index := range []string{"50.0.0.0", "200.0.0.0", "1.0.0.0", "50.0.0.0"} {
+			indexes <- index
+		}
+		close(indexes)
+	}()
+
+	var found []string
+	results := __blacklistTestHandle.CheckEntriesStream(context.Background(), CheckOptions{},
+		list.BlacklistedIPType, indexes)
+	for result := range results {
+		if len(result.Results) != 1 {
+			t.Fail()
+		}
+		found = append(found, result.Index)
+	}
+	if len(found) != 3 || found[0] != "50.0.0.0" || found[1] != "1.0.0.0" || found[2] != "50.0.0.0" {
+		t.Fail()
+	}
+}
+
+//newTestBlacklist returns a Blacklist backed by db. Errors are appended
+//to errs, or cause a panic if errs is nil.
+func newTestBlacklist(db database.Handle, errs *[]error) *Blacklist {
+	if errs == nil {
+		return NewBlacklist(db, func(err error) { panic(err) })
+	}
+	var errsMutex sync.Mutex
+	return NewBlacklist(db, func(err error) {
+		errsMutex.Lock()
+		*errs = append(*errs, err)
+		errsMutex.Unlock()
+	})
+}
+
+//stringData serves the value data holds at the time of each fetch
+func stringData(data *string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(*data)), nil
+	}
+}
+
+func TestCustomCIDRBL(t *testing.T) {
+	b := newTestBlacklist(database.NewMemoryDB(), nil)
+
+	data := `
+10.0.0.0/8
+10.1.2.0/24
+192.168.1.7/16
+2001:db8::/32
+`
+	b.SetLists(lists.NewLineSeparatedList(list.BlacklistedCIDRType, "test-cidr", 86400, stringData(&data)))
+	b.Update()
+
+	blIP := "10.1.2.3"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 2 {
+		t.Fail()
+	}
+
+	//netblocks are stored in their canonical form
+	blIP = "192.168.200.1"
+	results := b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]
+	if len(results) != 1 || results[0].Index != "192.168.0.0/16" {
+		t.Fail()
+	}
+
+	blIP = "2001:db8::1"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+		t.Fail()
+	}
+
+	blIP = "11.0.0.1"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) > 0 {
+		t.Fail()
+	}
+
+	blCIDR := "10.1.2.0/24"
+	if len(b.CheckEntries(list.BlacklistedCIDRType, blCIDR)[blCIDR]) != 1 {
+		t.Fail()
+	}
+}
+
+func TestSuffixMatchBL(t *testing.T) {
+	b := newTestBlacklist(database.NewMemoryDB(), nil)
+
+	newList := func(name string, data string, suffixMatch bool) list.List {
+		l := lists.NewLineSeparatedList(list.BlacklistedHostnameType, name, 86400, stringData(&data))
+		meta := l.GetMetadata()
+		meta.SuffixMatch = suffixMatch
+		l.SetMetadata(meta)
+		return l
+	}
+	b.SetLists(
+		newList("suffix", "evil.com\n", true),
+		newList("exact", "bad.org\nevil.com\n", false),
+	)
+	b.Update()
+
+	host := "c2.evil.com"
+	results := b.CheckEntries(list.BlacklistedHostnameType, host)[host]
+	if len(results) != 1 || results[0].List != "suffix" || results[0].Index != "evil.com" {
+		t.Fail()
+	}
+
+	host = "evil.com"
+	if len(b.CheckEntries(list.BlacklistedHostnameType, host)[host]) != 2 {
+		t.Fail()
+	}
+
+	host = "www.bad.org"
+	if len(b.CheckEntries(list.BlacklistedHostnameType, host)[host]) != 0 {
+		t.Fail()
+	}
+
+	//suffix matching may be requested for a single query
+	opts := CheckOptions{SuffixMatch: true}
+	results = b.CheckEntriesWithOptions(opts, list.BlacklistedHostnameType, host)[host]
+	if len(results) != 1 || results[0].Index != "bad.org" {
+		t.Fail()
+	}
+
+	host = "a.b.c2.evil.com"
+	if len(b.CheckEntriesWithOptions(opts, list.BlacklistedHostnameType, host)[host]) != 2 {
+		t.Fail()
+	}
+}
+
+func TestFailedRefreshBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(database.NewMemoryDB(), &errs)
+
+	data := "10.10.10.10\n"
+	var fetchErr error
+	getData := func() (io.ReadCloser, error) {
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return stringData(&data)()
+	}
+
+	//a cache time of 0 causes every update to refresh the list
+	b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "test", 0, getData))
+	b.Update()
+
+	blIP := "10.10.10.10"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+		t.Fail()
+	}
+
+	//the old entries are kept if the refresh fails
+	fetchErr = errors.New("download failed")
+	b.Update()
+	if len(errs) != 1 || !errors.Is(errs[0], fetchErr) {
+		t.Fail()
+	}
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+		t.Fail()
+	}
+
+	//the entries are replaced if the refresh succeeds
+	fetchErr = nil
+	data = "10.10.10.11\n"
+	b.Update()
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 {
+		t.Fail()
+	}
+	blIP = "10.10.10.11"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+		t.Fail()
+	}
+}
+
+func TestIncrementalUpdateBL(t *testing.T) {
+	for _, incremental := range []bool{true, false} {
+		b := newTestBlacklist(database.NewMemoryDB(), nil)
+		b.SetIncrementalUpdates(incremental)
+
+		data := "10.10.10.10\n10.10.10.11\n10.10.10.12\n"
+		b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "test", 0, stringData(&data)))
+
+		reports := b.Update()
+		if len(reports) != 1 || reports[0].Added != 3 || reports[0].Removed != 0 {
+			t.Fail()
+		}
+
+		data = "10.10.10.10\n10.10.10.11\n10.10.10.13\n"
+		reports = b.Update()
+		if len(reports) != 1 {
+			t.FailNow()
+		}
+		if incremental && (reports[0].Added != 1 || reports[0].Removed != 1) {
+			t.Fail()
+		}
+		if !incremental && reports[0].Added != 3 {
+			t.Fail()
+		}
+
+		for ip, count := range map[string]int{
+			"10.10.10.10": 1, "10.10.10.12": 0, "10.10.10.13": 1,
+		} {
+			if len(b.CheckEntries(list.BlacklistedIPType, ip)[ip]) != count {
+				t.Fail()
+			}
+		}
+	}
+}
+
+//endlessReader produces the same line forever
+type endlessReader struct{ line []byte }
+
+func (e endlessReader) Read(p []byte) (int, error) {
+	n := 0
+	for n+len(e.line) <= len(p) {
+		n += copy(p[n:], e.line)
+	}
+	return n, nil
+}
+
+func (endlessReader) Close() error { return nil }
+
+func TestCancelledUpdateBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(database.NewMemoryDB(), &errs)
+
+	endless := false
+	data := "10.10.10.10\n"
+	getData := func(ctx context.Context) (io.ReadCloser, error) {
+		if endless {
+			return endlessReader{[]byte("10.10.10.11\n")}, nil
+		}
+		return stringData(&data)()
+	}
+	b.SetLists(lists.NewLineSeparatedListContext(list.BlacklistedIPType, "test", 0, getData))
+	b.Update()
+
+	//the update must return once the context expires
+	endless = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b.UpdateContext(ctx)
+
+	if len(errs) == 0 || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Fail()
+	}
+
+	//the interrupted refresh keeps the old entries
+	blIP := "10.10.10.10"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+		t.Fail()
+	}
+	blIP = "10.10.10.11"
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 {
+		t.Fail()
+	}
+
+	//checks against a cancelled context return no results
+	errs = nil
+	cancelledCtx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	blIP = "10.10.10.10"
+	if len(b.CheckEntriesContext(cancelledCtx, list.BlacklistedIPType, blIP)[blIP]) != 0 {
+		t.Fail()
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fail()
+	}
+}
+
+func TestConcurrentUpdateBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(database.NewMemoryDB(), &errs)
+	b.SetUpdateWorkers(2)
+
+	//each of the first two lists waits for the other to start fetching,
+	//so the update only succeeds if they are fetched concurrently
+	started := make(chan struct{}, 2)
+	data := "10.10.10.10\n"
+	newList := func(name string) list.List {
+		return lists.NewLineSeparatedList(list.BlacklistedIPType, name, 86400,
+			func() (io.ReadCloser, error) {
+				started <- struct{}{}
+				deadline := time.Now().Add(5 * time.Second)
+				for len(started) < 2 {
+					if time.Now().After(deadline) {
+						return nil, errors.New("lists were not fetched concurrently")
+					}
+					time.Sleep(time.Millisecond)
+				}
+				return stringData(&data)()
+			},
+		)
+	}
+	fetchErr := errors.New("download failed")
+	failingList := lists.NewLineSeparatedList(list.BlacklistedIPType, "failing", 86400,
+		func() (io.ReadCloser, error) { return nil, fetchErr },
+	)
+	b.SetLists(newList("a"), newList("b"), failingList)
+
+	reports := b.Update()
+	if len(reports) != 3 {
+		t.FailNow()
+	}
+	for i, name := range []string{"a", "b"} {
+		if reports[i].Name != name || reports[i].Added != 1 || len(reports[i].Errors) != 0 {
+			t.Fail()
+		}
+	}
+	//errors are reported for the list which produced them
+	if reports[2].Name != "failing" || len(reports[2].Errors) != 1 || !errors.Is(reports[2].Errors[0], fetchErr) {
+		t.Fail()
+	}
+}
+
+func TestUpdateReportBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(database.NewMemoryDB(), &errs)
+
+	data := "10.10.10.10\nnot an ip\n10.10.10.11\n"
+	cached := lists.NewLineSeparatedList(list.BlacklistedIPType, "cached", 86400, stringData(&data))
+	uncached := lists.NewLineSeparatedList(list.BlacklistedIPType, "uncached", 0, stringData(&data))
+	b.SetLists(cached, uncached)
+
+	reports := b.Update()
+	if len(reports) != 2 {
+		t.FailNow()
+	}
+	for _, report := range reports {
+		if report.Action != ActionCreated || report.Fetched != 3 ||
+			report.Rejected != 1 || report.Inserted != 2 || len(report.Errors) != 1 {
+			t.Errorf("unexpected report after create: %+v", report)
+		}
+	}
+
+	//the cached list is skipped while the uncached list is refreshed
+	reports = b.Update()
+	if len(reports) != 2 {
+		t.FailNow()
+	}
+	if reports[0].Name != "cached" || reports[0].Action != ActionSkipped || reports[0].Fetched != 0 {
+		t.Errorf("unexpected report for cached list: %+v", reports[0])
+	}
+	if reports[1].Name != "uncached" || reports[1].Action != ActionRefreshed ||
+		reports[1].Fetched != 3 || reports[1].Rejected != 1 || reports[1].Inserted != 0 {
+		t.Errorf("unexpected report for uncached list: %+v", reports[1])
+	}
+
+	//lists which are no longer loaded are removed
+	b.SetLists(cached)
+	reports = b.Update()
+	if len(reports) != 2 {
+		t.FailNow()
+	}
+	if reports[0].Name != "uncached" || reports[0].Action != ActionRemoved || len(reports[0].Errors) != 0 {
+		t.Errorf("unexpected report for removed list: %+v", reports[0])
+	}
+	if reports[1].Name != "cached" || reports[1].Action != ActionSkipped {
+		t.Errorf("unexpected report for cached list: %+v", reports[1])
+	}
+}
+
+//rejectingDB is a Handle which refuses to insert the entry with a given index
+type rejectingDB struct {
+	database.Handle
+	reject string
+}
+
+func (r rejectingDB) InsertEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	defer wg.Done()
+	accepted := make(chan list.BlacklistedEntry)
+	var inserted sync.WaitGroup
+	inserted.Add(1)
+	go r.Handle.InsertEntries(ctx, entryType, accepted, &inserted, errorsOut)
+	for entry := range entries {
+		if entry.Index == r.reject {
+			errorsOut <- &database.WriteError{Entries: 1, Err: errors.New("rejected")}
+			continue
+		}
+		accepted <- entry
+	}
+	close(accepted)
+	inserted.Wait()
+}
+
+func TestUpdateReportRejectedInsertBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(rejectingDB{Handle: database.NewMemoryDB(), reject: "10.10.10.11"}, &errs)
+	data := "10.10.10.10\n10.10.10.11\n10.10.10.12\n"
+	b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "rejecting", 86400, stringData(&data)))
+
+	//only the entries the database accepted are counted as inserted
+	reports := b.Update()
+	if len(reports) != 1 || reports[0].Fetched != 3 || reports[0].Inserted != 2 || len(reports[0].Errors) != 1 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+	if len(errs) != 1 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestTypedErrorsBL(t *testing.T) {
+	var errs []error
+	b := newTestBlacklist(database.NewMemoryDB(), &errs)
+
+	data := "10.10.10.10\nnot an ip\n"
+	fetchErr := errors.New("download failed")
+	b.SetLists(
+		lists.NewLineSeparatedList(list.BlacklistedIPType, "bad line", 0, stringData(&data)),
+		lists.NewLineSeparatedList(list.BlacklistedIPType, "failing", 0,
+			func() (io.ReadCloser, error) { return nil, fetchErr },
+		),
+	)
+	b.Update()
+
+	if len(errs) != 2 {
+		t.FailNow()
+	}
+	categories := make(map[list.ErrorCategory]*list.BlacklistError)
+	for _, err := range errs {
+		var blErr *list.BlacklistError
+		if !errors.As(err, &blErr) {
+			t.Fatalf("error %v is not a BlacklistError", err)
+		}
+		categories[blErr.Category] = blErr
+	}
+
+	validateErr := categories[list.CategoryValidate]
+	if validateErr == nil || validateErr.List != "bad line" ||
+		validateErr.EntryType != list.BlacklistedIPType || validateErr.Index != "not an ip" {
+		t.Errorf("unexpected validation error: %v", validateErr)
+	}
+	fetchFailure := categories[list.CategoryFetch]
+	if fetchFailure == nil || fetchFailure.List != "failing" || !errors.Is(fetchFailure, fetchErr) {
+		t.Errorf("unexpected fetch error: %v", fetchFailure)
+	}
+}
+
+//countingDB counts the batch lookups, filter loads and list metadata
+//reads which reach the database
+type countingDB struct {
+	database.Handle
+	lookups     int
+	filterLoads int
+	listReads   int
+}
+
+func (c *countingDB) GetRegisteredLists(ctx context.Context) ([]list.Metadata, error) {
+	c.listReads++
+	return c.Handle.GetRegisteredLists(ctx)
+}
+
+func (c *countingDB) GetFilters(ctx context.Context, l list.Metadata) (map[list.BlacklistedEntryType][]byte, error) {
+	c.filterLoads++
+	return c.Handle.GetFilters(ctx, l)
+}
+
+func (c *countingDB) FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+	indexes []string) (map[string][]database.BlacklistResult, error) {
+	c.lookups++
+	return c.Handle.FindEntriesBatch(ctx, dataType, indexes)
+}
+
+func TestBloomFilterBL(t *testing.T) {
+	for _, incremental := range []bool{true, false} {
+		db := &countingDB{Handle: database.NewMemoryDB()}
+		b := newTestBlacklist(db, nil)
+		b.SetIncrementalUpdates(incremental)
+		b.SetBloomFilters(0.001)
+
+		data := "10.10.10.10\n10.10.10.11\n"
+		b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "test", 0, stringData(&data)))
+		b.Update()
+
+		metas, err := db.GetRegisteredLists(context.Background())
+		if err != nil || len(metas) != 1 || metas[0].FilterVersion == 0 {
+			t.Fatal("the list does not hold a bloom filter")
+		}
+		filters, err := db.GetFilters(context.Background(), metas[0])
+		if err != nil || len(filters[list.BlacklistedIPType]) == 0 {
+			t.Fatal("bloom filter was not stored")
+		}
+		db.filterLoads = 0
+
+		//absent indexes skip the database
+		blIP := "192.168.0.1"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 || db.lookups != 0 {
+			t.Errorf("absent index reached the database %d times", db.lookups)
+		}
+		blIP = "10.10.10.10"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 || db.lookups != 1 {
+			t.Fail()
+		}
+		//the filters are only loaded once
+		if db.filterLoads != 1 {
+			t.Errorf("filters were loaded %d times", db.filterLoads)
+		}
+
+		//stored filters are used without enabling bloom filters. The
+		//checker stands in for another process sharing the database.
+		checker := newTestBlacklist(db, nil)
+		checker.SetMetadataCacheTime(0)
+		blIP = "192.168.0.1"
+		if len(checker.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 || db.lookups != 1 {
+			t.Errorf("absent index reached the database %d times", db.lookups)
+		}
+
+		//refreshed lists rebuild their filters
+		data = "10.10.10.12\n"
+		b.Update()
+		blIP = "10.10.10.12"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Fail()
+		}
+		blIP = "10.10.10.10"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 {
+			t.Fail()
+		}
+
+		//lists refreshed without bloom filters drop their stale filters
+		b.SetBloomFilters(0)
+		data = "10.10.10.13\n"
+		b.Update()
+		metas, err = db.GetRegisteredLists(context.Background())
+		if err != nil || len(metas) != 1 || metas[0].FilterVersion != 0 {
+			t.Fatal("the list kept its bloom filter")
+		}
+		blIP = "10.10.10.13"
+		if len(checker.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Fail()
+		}
+	}
+}
+
+func TestMetadataCacheBL(t *testing.T) {
+	db := &countingDB{Handle: database.NewMemoryDB()}
+	b := newTestBlacklist(db, nil)
+	data := "10.10.10.10\n"
+	b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "test", 0, stringData(&data)))
+	b.Update()
+
+	//checks reuse the metadata of the registered lists
+	db.listReads = 0
+	blIP := "10.10.10.10"
+	for i := 0; i < 3; i++ {
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Fail()
+		}
+	}
+	if db.listReads != 1 {
+		t.Errorf("metadata was read %d times", db.listReads)
+	}
+
+	//Update discards the cached metadata
+	b.SetLists()
+	b.Update()
+	db.listReads = 0
+	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 || db.listReads != 1 {
+		t.Errorf("removed list was checked with stale metadata")
+	}
+
+	//the metadata is read on every check if caching is disabled
+	b.SetMetadataCacheTime(0)
+	b.CheckEntries(list.BlacklistedIPType, blIP)
+	b.CheckEntries(list.BlacklistedIPType, blIP)
+	if db.listReads != 3 {
+		t.Errorf("metadata was read %d times", db.listReads)
+	}
+}
+
+//checkingDB runs check each time the visible entries of a list change,
+//before the Update writes the list's new metadata
+type checkingDB struct {
+	database.Handle
+	check func()
+}
+
+func (c checkingDB) CommitStagedEntries(ctx context.Context, l list.Metadata) error {
+	err := c.Handle.CommitStagedEntries(ctx, l)
+	c.check()
+	return err
+}
+
+func (c checkingDB) UpdateEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType,
+	added []list.BlacklistedEntry, removed []string) error {
+	err := c.Handle.UpdateEntries(ctx, l, entryType, added, removed)
+	c.check()
+	return err
+}
+
+func TestBloomFilterRefreshBL(t *testing.T) {
+	for _, incremental := range []bool{true, false} {
+		db := &checkingDB{Handle: database.NewMemoryDB(), check: func() {}}
+		b := newTestBlacklist(db, nil)
+		b.SetIncrementalUpdates(incremental)
+		b.SetBloomFilters(0.001)
+
+		data := "10.10.10.10\n"
+		b.SetLists(lists.NewLineSeparatedList(list.BlacklistedIPType, "test", 0, stringData(&data)))
+		b.Update()
+
+		//both the updating Blacklist and the checker, which stands in for
+		//another process sharing the database, cache the filters built
+		//from the old entries
+		checker := newTestBlacklist(db, nil)
+		checker.SetMetadataCacheTime(0)
+		blIP := "10.10.10.11"
+		for _, c := range []*Blacklist{b, checker} {
+			if len(c.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 0 {
+				t.Fail()
+			}
+		}
+
+		//lookups made between writing the new entries and storing
+		//the new filters must find the new entries
+		checked := false
+		db.check = func() {
+			checked = true
+			for _, c := range []*Blacklist{b, checker} {
+				if len(c.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+					t.Errorf("new entry was screened out by a stale filter (incremental: %v)", incremental)
+				}
+			}
+		}
+		data = "10.10.10.11\n"
+		b.Update()
+		if !checked {
+			t.Fatal("the list was not refreshed")
+		}
+
+		db.check = func() {}
+		if len(checker.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Fail()
+		}
+		metas, err := db.GetRegisteredLists(context.Background())
+		if err != nil || len(metas) != 1 || metas[0].FilterVersion == 0 {
+			t.Error("the refreshed list does not hold a bloom filter")
+		}
+	}
+}
+
+func TestConditionalWebListBL(t *testing.T) {
+	for _, incremental := range []bool{true, false} {
+		etag := `"v1"`
+		data := "10.10.10.10\n"
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			io.WriteString(w, data)
+		}))
+
+		b := newTestBlacklist(database.NewMemoryDB(), nil)
+		b.SetIncrementalUpdates(incremental)
+		b.SetLists(lists.NewLineSeparatedWebList(list.BlacklistedIPType, "web", 0, server.URL, nil))
+
+		reports := b.Update()
+		if reports[0].Action != ActionCreated || reports[0].Inserted != 1 {
+			t.Errorf("unexpected report for new list: %+v", reports[0])
+		}
+
+		//the server reports the list is unchanged
+		reports = b.Update()
+		if reports[0].Action != ActionUnchanged || requests != 2 {
+			t.Errorf("unexpected report for 304 response: %+v", reports[0])
+		}
+
+		//the etag changed, but the content hash did not
+		etag = `"v2"`
+		reports = b.Update()
+		if reports[0].Action != ActionUnchanged || reports[0].Fetched != 0 {
+			t.Errorf("unexpected report for unchanged content: %+v", reports[0])
+		}
+
+		blIP := "10.10.10.10"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Error("unchanged list lost its entries")
+		}
+
+		//new content is written to the database
+		etag = `"v3"`
+		data = "10.10.10.11\n"
+		reports = b.Update()
+		if reports[0].Action != ActionRefreshed || reports[0].Inserted != 1 {
+			t.Errorf("unexpected report for changed content: %+v", reports[0])
+		}
+		blIP = "10.10.10.11"
+		if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) != 1 {
+			t.Error("changed list was not refreshed")
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+//Filter is a bloom filter over strings. A Filter never reports that an
+//added string is absent, but may report that a string which was never
+//added is present.
+type Filter struct {
+	bits   []uint64
+	m      uint64
+	hashes uint32
+}
+
+//headerSize is the number of bytes preceding the bits of an encoded Filter
+const headerSize = 12
+
+//New creates a Filter sized to hold n strings with the given
+//false positive rate, e.g. 0.01 for one false positive in a hundred
+func New(n int, falsePositiveRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	//optimal number of bits and hash functions for n strings
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	words := (uint64(m) + 63) / 64
+	return &Filter{
+		bits:   make([]uint64, words),
+		m:      words * 64,
+		hashes: uint32(k),
+	}
+}
+
+//Add adds a string to the filter
+func (f *Filter) Add(s string) {
+	h1, h2 := hashString(s)
+	for i := uint32(0); i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+//Test returns false if the string was definitely not added to the filter
+func (f *Filter) Test(s string) bool {
+	h1, h2 := hashString(s)
+	for i := uint32(0); i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//MarshalBinary encodes the filter so it can be stored alongside
+//list metadata
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, headerSize+8*len(f.bits))
+	binary.BigEndian.PutUint64(data[0:8], f.m)
+	binary.BigEndian.PutUint32(data[8:12], f.hashes)
+	for i, word := range f.bits {
+		binary.BigEndian.PutUint64(data[headerSize+8*i:], word)
+	}
+	return data, nil
+}
+
+//UnmarshalBinary decodes a filter encoded with MarshalBinary
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize || (len(data)-headerSize)%8 != 0 {
+		return errors.New("invalid bloom filter encoding")
+	}
+	m := binary.BigEndian.Uint64(data[0:8])
+	hashes := binary.BigEndian.Uint32(data[8:12])
+	words := (len(data) - headerSize) / 8
+	if m == 0 || m != uint64(words)*64 || hashes == 0 {
+		return errors.New("invalid bloom filter encoding")
+	}
+	f.bits = make([]uint64, words)
+	for i := range f.bits {
+		f.bits[i] = binary.BigEndian.Uint64(data[headerSize+8*i:])
+	}
+	f.m = m
+	f.hashes = hashes
+	return nil
+}
+
+//hashString returns the two hashes used to derive the bit positions of a
+//string. The second hash is odd so the positions don't collapse together.
+func hashString(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	h.Write([]byte(s))
+	h2 := h.Sum64() | 1
+	return h1, h2
+}
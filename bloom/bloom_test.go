@@ -0,0 +1,53 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+
+	//added strings are never reported absent
+	for i := 0; i < n; i++ {
+		if !f.Test(fmt.Sprintf("10.0.%d.%d", i/256, i%256)) {
+			t.Fatalf("added string %d reported absent", i)
+		}
+	}
+
+	//the false positive rate stays near the requested rate
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.Test(fmt.Sprintf("192.168.%d.%d", i/256, i%256)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > n/50 {
+		t.Errorf("too many false positives: %d", falsePositives)
+	}
+}
+
+func TestFilterEncoding(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("evil.com")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(Filter)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Test("evil.com") {
+		t.Error("decoded filter lost an added string")
+	}
+
+	if err := decoded.UnmarshalBinary(data[:5]); err == nil {
+		t.Error("truncated filter decoded without an error")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/config"
+)
+
+//runUpdate fetches the lists declared in a config file into the database.
+//Registered lists which are not declared in the file are removed.
+func runUpdate(ctx context.Context, c *cli, args []string) error {
+	common := new(commonFlags)
+	flags := c.newFlagSet("update", "-config FILE [flags]", common)
+	configPath := flags.String("config", "", "YAML or JSON file declaring the lists")
+	workers := flags.Int("workers", 4, "number of lists updated at once")
+	full := flags.Bool("full", false, "replace the entries of existing lists instead of applying changes")
+	bloomRate := flags.Float64("bloom", 0, "false positive rate of the bloom filters built for each list, 0 to build none")
+	err := c.parseFlags(flags, common, args)
+	if err != nil {
+		return err
+	}
+	if *configPath == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	lists, err := config.LoadLists(*configPath)
+	if err != nil {
+		return err
+	}
+	db, err := c.openDB(common.db)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	errorCount := 0
+	b := blacklist.NewBlacklist(db, func(err error) {
+		errorCount++
+		fmt.Fprintln(c.stderr, err)
+	})
+	b.SetLists(lists...)
+	b.SetUpdateWorkers(*workers)
+	b.SetIncrementalUpdates(!*full)
+	b.SetBloomFilters(*bloomRate)
+	reports := b.UpdateContext(ctx)
+
+	err = writeReports(c.stdout, common.output, reports)
+	if err != nil {
+		return err
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("%d errors occurred during the update", errorCount)
+	}
+	return ctx.Err()
+}
+
+//runLists shows the lists registered with the database
+func runLists(ctx context.Context, c *cli, args []string) error {
+	common := new(commonFlags)
+	flags := c.newFlagSet("lists", "[flags]", common)
+	err := c.parseFlags(flags, common, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	db, err := c.openDB(common.db)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+	metas, err := db.GetRegisteredLists(ctx)
+	if err != nil {
+		return err
+	}
+	return writeLists(c.stdout, common.output, metas)
+}
+
+//runRemove removes the named lists from the database. Nothing is removed
+//if any of the names is not registered.
+func runRemove(ctx context.Context, c *cli, args []string) error {
+	common := new(commonFlags)
+	flags := c.newFlagSet("remove", "[flags] NAME...", common)
+	err := c.parseFlags(flags, common, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	db, err := c.openDB(common.db)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+	metas, err := db.GetRegisteredLists(ctx)
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]list.Metadata)
+	for _, meta := range metas {
+		registered[meta.Name] = meta
+	}
+	toRemove := make([]list.Metadata, 0, flags.NArg())
+	for _, name := range flags.Args() {
+		meta, ok := registered[name]
+		if !ok {
+			return fmt.Errorf("list %q is not registered", name)
+		}
+		toRemove = append(toRemove, meta)
+	}
+
+	removed := make([]string, 0, len(toRemove))
+	for _, meta := range toRemove {
+		err = db.RemoveList(ctx, meta)
+		if err != nil {
+			writeRemoved(c.stdout, common.output, removed)
+			return fmt.Errorf("removing list %s: %w", meta.Name, err)
+		}
+		removed = append(removed, meta.Name)
+	}
+	return writeRemoved(c.stdout, common.output, removed)
+}
+
+//runCheck checks the indexes given as arguments against the database, or
+//the indexes read from stdin, one per line, if none are given. Only the
+//indexes which matched are written.
+func runCheck(ctx context.Context, c *cli, args []string) error {
+	common := new(commonFlags)
+	flags := c.newFlagSet("check", "[flags] [INDEX...]", common)
+	entryType := flags.String("type", string(list.BlacklistedIPType),
+		"type of the indexes: ip, hostname, or url")
+	suffixMatch := flags.Bool("suffix", false, "match hostnames against the entries of their parent domains")
+	batchSize := flags.Int("batch", 1000, "number of indexes checked at once")
+	err := c.parseFlags(flags, common, args)
+	if err != nil {
+		return err
+	}
+	if !list.KnownEntryType(list.BlacklistedEntryType(*entryType)) {
+		fmt.Fprintf(c.stderr, "invalid -type %q\n", *entryType)
+		flags.Usage()
+		return errUsage
+	}
+
+	db, err := c.openDB(common.db)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	errorMutex := new(sync.Mutex)
+	errorCount := 0
+	b := blacklist.NewBlacklist(db, func(err error) {
+		errorMutex.Lock()
+		defer errorMutex.Unlock()
+		errorCount++
+		fmt.Fprintln(c.stderr, err)
+	})
+	b.SetCheckBatchSize(*batchSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(indexes)
+		readErr <- c.readIndexes(ctx, flags.Args(), indexes)
+	}()
+
+	results := b.CheckEntriesStream(ctx, blacklist.CheckOptions{SuffixMatch: *suffixMatch},
+		list.BlacklistedEntryType(*entryType), indexes)
+	err = writeCheckResults(c.stdout, common.output, results)
+	cancel()
+	if err != nil {
+		return err
+	}
+	err = <-readErr
+	if err != nil {
+		return fmt.Errorf("reading stdin: %w", err)
+	}
+	errorMutex.Lock()
+	defer errorMutex.Unlock()
+	if errorCount > 0 {
+		return fmt.Errorf("%d errors occurred during the check", errorCount)
+	}
+	return nil
+}
+
+//readIndexes sends the given arguments to indexes, or the lines read from
+//stdin if there are none. Blank lines and lines starting with # are skipped.
+func (c *cli) readIndexes(ctx context.Context, args []string, indexes chan<- string) error {
+	send := func(index string) bool {
+		select {
+		case indexes <- index:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if len(args) > 0 {
+		for _, index := range args {
+			if !send(index) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(c.stdin)
+	for scanner.Scan() {
+		index := strings.TrimSpace(scanner.Text())
+		if index == "" || strings.HasPrefix(index, "#") {
+			continue
+		}
+		if !send(index) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,205 @@
+//Command rita-bl manages and queries a rita-bl blacklist database.
+//
+//Usage:
+//
+//	rita-bl <command> [flags] [arguments]
+//
+//The commands are:
+//
+//	update   fetch the lists declared in a config file into the database
+//	lists    show the lists registered with the database
+//	remove   remove lists from the database
+//	check    check indexes given as arguments or read from stdin
+//
+//Every command accepts -mongo, -auth, and -database to select the MongoDB
+//database, -bolt to use a bolt database file instead, and -output to
+//select table or json output.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/activecm/mgosec"
+	"github.com/activecm/rita-bl/database"
+)
+
+//errUsage is returned by commands which were given invalid arguments
+//after the usage has been printed
+var errUsage = errors.New("invalid usage")
+
+//dbOptions selects the database used by a command
+type dbOptions struct {
+	mongo    string
+	auth     string
+	database string
+	bolt     string
+}
+
+//commonFlags holds the flags accepted by every command
+type commonFlags struct {
+	db     dbOptions
+	output string
+}
+
+//command is a subcommand of rita-bl
+type command struct {
+	//summary describes the command in the command list
+	summary string
+	//run runs the command with the arguments following its name
+	run func(ctx context.Context, c *cli, args []string) error
+}
+
+//commands maps the names of the subcommands to their implementations
+var commands = map[string]command{
+	"update": {
+		summary: "fetch the lists declared in a config file into the database",
+		run:     runUpdate,
+	},
+	"lists": {
+		summary: "show the lists registered with the database",
+		run:     runLists,
+	},
+	"remove": {
+		summary: "remove lists from the database",
+		run:     runRemove,
+	},
+	"check": {
+		summary: "check indexes given as arguments or read from stdin",
+		run:     runCheck,
+	},
+}
+
+//cli holds the streams and database connection used by the commands
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	//openDB connects to the database selected by the common flags
+	openDB func(opts dbOptions) (database.Handle, error)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &cli{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		openDB: openDB,
+	}
+	os.Exit(c.run(ctx, os.Args[1:]))
+}
+
+//run runs the command named by the first argument and returns the
+//process exit code
+func (c *cli) run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		c.printUsage()
+		return 2
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		c.printUsage()
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "rita-bl: unknown command %q\n", args[0])
+		c.printUsage()
+		return 2
+	}
+
+	err := cmd.run(ctx, c, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if errors.Is(err, errUsage) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "rita-bl %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+//printUsage prints the available commands
+func (c *cli) printUsage() {
+	fmt.Fprintln(c.stderr, "Usage: rita-bl <command> [flags] [arguments]")
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Run rita-bl <command> -h for the flags of a command.")
+}
+
+//newFlagSet creates the flag set of a command with the common flags
+//registered in common. usage describes the arguments of the command.
+func (c *cli) newFlagSet(name, usage string, common *commonFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: rita-bl %s %s\n\nFlags:\n", name, usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&common.db.mongo, "mongo", "mongodb://localhost:27017",
+		"MongoDB connection string")
+	flags.StringVar(&common.db.auth, "auth", "",
+		"MongoDB authentication mechanism, e.g. SCRAM-SHA-1")
+	flags.StringVar(&common.db.database, "database", "rita-bl",
+		"MongoDB database holding the blacklist")
+	flags.StringVar(&common.db.bolt, "bolt", "",
+		"path to a bolt database file to use instead of MongoDB")
+	flags.StringVar(&common.output, "output", outputTable, "output format: table or json")
+	return flags
+}
+
+//parseFlags parses the arguments of a command and checks the common flags
+func (c *cli) parseFlags(flags *flag.FlagSet, common *commonFlags, args []string) error {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	if err != nil {
+		return errUsage
+	}
+	if common.output != outputTable && common.output != outputJSON {
+		fmt.Fprintf(c.stderr, "invalid -output %q: must be table or json\n", common.output)
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+//openDB connects to the database selected by opts
+func openDB(opts dbOptions) (database.Handle, error) {
+	if opts.bolt != "" {
+		return database.NewBoltDB(opts.bolt)
+	}
+	authMechanism, err := mgosec.ParseAuthMechanism(opts.auth)
+	if err != nil {
+		return nil, err
+	}
+	return database.NewMongoDB(opts.mongo, authMechanism, opts.database)
+}
+
+//closeDB releases the resources held by a database opened with openDB,
+//such as the file lock of a bolt database
+func closeDB(db database.Handle) {
+	if closer, ok := db.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/activecm/rita-bl/database"
+)
+
+//runCLI runs rita-bl against db and returns the exit code and output
+func runCLI(db database.Handle, stdin string, args ...string) (int, string, string) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: stdout,
+		stderr: stderr,
+		openDB: func(dbOptions) (database.Handle, error) { return db, nil },
+	}
+	code := c.run(context.Background(), args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ips.txt"), []byte("10.10.10.10\n10.10.10.11\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "lists.yaml")
+	err = os.WriteFile(configPath, []byte("lists:\n  - name: ips\n    file: ips.txt\n    type: ip\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.NewMemoryDB()
+
+	code, stdout, stderr := runCLI(db, "", "update", "-config", configPath)
+	if code != 0 || !strings.Contains(stdout, "created") {
+		t.Fatalf("update failed with %d: %s%s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLI(db, "", "lists", "-output", "json")
+	var lists []listOutput
+	if code != 0 || json.Unmarshal([]byte(stdout), &lists) != nil {
+		t.Fatalf("lists failed with %d: %s%s", code, stdout, stderr)
+	}
+	if len(lists) != 1 || lists[0].Name != "ips" || lists[0].LastUpdate == 0 {
+		t.Errorf("unexpected lists %+v", lists)
+	}
+
+	code, stdout, _ = runCLI(db, "", "check", "10.10.10.10", "127.0.0.1")
+	if code != 0 || !strings.Contains(stdout, "10.10.10.10") || strings.Contains(stdout, "127.0.0.1") {
+		t.Errorf("unexpected check output %d: %s", code, stdout)
+	}
+
+	code, stdout, _ = runCLI(db, "# comment\n10.10.10.11\n\n127.0.0.1\n", "check", "-output", "json")
+	var results []checkOutput
+	if code != 0 || json.Unmarshal([]byte(stdout), &results) != nil {
+		t.Fatalf("check from stdin failed with %d: %s", code, stdout)
+	}
+	if len(results) != 1 || results[0].Index != "10.10.10.11" || results[0].Results[0].List != "ips" {
+		t.Errorf("unexpected check results %+v", results)
+	}
+
+	code, _, _ = runCLI(db, "", "remove", "missing")
+	if code != 1 {
+		t.Errorf("removing an unregistered list exited with %d", code)
+	}
+	code, stdout, _ = runCLI(db, "", "remove", "ips")
+	if code != 0 || stdout != "removed ips\n" {
+		t.Errorf("unexpected remove output %d: %s", code, stdout)
+	}
+	code, stdout, _ = runCLI(db, "", "lists", "-output", "json")
+	if code != 0 || strings.TrimSpace(stdout) != "[]" {
+		t.Errorf("list was not removed: %s", stdout)
+	}
+}
+
+func TestCLIUsage(t *testing.T) {
+	db := database.NewMemoryDB()
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"update"},
+		{"remove"},
+		{"lists", "-output", "xml"},
+		{"check", "-type", "email", "a@b.c"},
+	} {
+		code, _, _ := runCLI(db, "", args...)
+		if code != 2 {
+			t.Errorf("%v exited with %d, expected 2", args, code)
+		}
+	}
+}
+
+//closingDB counts the times it is closed
+type closingDB struct {
+	database.Handle
+	closes int
+}
+
+func (c *closingDB) Close() error {
+	c.closes++
+	return nil
+}
+
+func TestCLIClosesDB(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ips.txt"), []byte("10.10.10.10\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "lists.yaml")
+	err = os.WriteFile(configPath, []byte("lists:\n  - name: ips\n    file: ips.txt\n    type: ip\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &closingDB{Handle: database.NewMemoryDB()}
+	for i, args := range [][]string{
+		{"update", "-config", configPath},
+		{"lists"},
+		{"check", "10.10.10.10"},
+		{"remove", "ips"},
+	} {
+		code, _, stderr := runCLI(db, "", args...)
+		if code != 0 {
+			t.Errorf("%v exited with %d: %s", args, code, stderr)
+		}
+		if db.closes != i+1 {
+			t.Errorf("%v did not close the database", args)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+const (
+	//outputTable prints results as aligned columns
+	outputTable = "table"
+	//outputJSON prints results as a JSON array
+	outputJSON = "json"
+)
+
+type (
+	//listOutput is the JSON form of a registered list
+	listOutput struct {
+		Name        string   `json:"name"`
+		Types       []string `json:"types"`
+		LastUpdate  int64    `json:"lastUpdate"`
+		CacheTime   int64    `json:"cacheTime"`
+		SuffixMatch bool     `json:"suffixMatch"`
+	}
+
+	//checkOutput is the JSON form of a blacklist.CheckResult
+	checkOutput struct {
+		Index   string                     `json:"index"`
+		Results []database.BlacklistResult `json:"results"`
+	}
+)
+
+//writeJSON writes value as indented JSON
+func writeJSON(out io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+//newTable creates a tabwriter which writes the given column headers
+func newTable(out io.Writer, headers ...string) *tabwriter.Writer {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(headers, "\t"))
+	return table
+}
+
+//writeLists writes the metadata of the registered lists
+func writeLists(out io.Writer, format string, metas []list.Metadata) error {
+	if format == outputJSON {
+		lists := make([]listOutput, len(metas))
+		for i, meta := range metas {
+			lists[i] = listOutput{
+				Name:        meta.Name,
+				Types:       typeNames(meta.Types),
+				LastUpdate:  meta.LastUpdate,
+				CacheTime:   meta.CacheTime,
+				SuffixMatch: meta.SuffixMatch,
+			}
+		}
+		return writeJSON(out, lists)
+	}
+
+	table := newTable(out, "NAME", "TYPES", "LAST UPDATE", "CACHE TIME")
+	for _, meta := range metas {
+		lastUpdate := "never"
+		if meta.LastUpdate > 0 {
+			lastUpdate = time.Unix(meta.LastUpdate, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", meta.Name,
+			strings.Join(typeNames(meta.Types), ","), lastUpdate,
+			time.Duration(meta.CacheTime)*time.Second)
+	}
+	return table.Flush()
+}
+
+//writeReports writes the reports returned by an update
+func writeReports(out io.Writer, format string, reports []blacklist.ListReport) error {
+	if format == outputJSON {
+		return writeJSON(out, reports)
+	}
+
+	table := newTable(out, "NAME", "ACTION", "FETCHED", "REJECTED", "ADDED", "REMOVED", "DURATION", "ERRORS")
+	for _, report := range reports {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%d\n", report.Name, report.Action,
+			report.Fetched, report.Rejected, report.Added, report.Removed,
+			report.Duration.Round(time.Millisecond), len(report.Errors))
+	}
+	return table.Flush()
+}
+
+//writeRemoved writes the names of the removed lists
+func writeRemoved(out io.Writer, format string, names []string) error {
+	if format == outputJSON {
+		return writeJSON(out, names)
+	}
+	for _, name := range names {
+		fmt.Fprintf(out, "removed %s\n", name)
+	}
+	return nil
+}
+
+//writeCheckResults writes the results read from a check until the channel
+//is closed. Each entry an index matched is written on its own row.
+func writeCheckResults(out io.Writer, format string, results <-chan blacklist.CheckResult) error {
+	if format == outputJSON {
+		outputs := make([]checkOutput, 0)
+		for result := range results {
+			outputs = append(outputs, checkOutput{Index: result.Index, Results: result.Results})
+		}
+		return writeJSON(out, outputs)
+	}
+
+	table := newTable(out, "INDEX", "LIST", "MATCHED")
+	for result := range results {
+		for _, entry := range result.Results {
+			fmt.Fprintf(table, "%s\t%s\t%s\n", result.Index, entry.List, entry.Index)
+		}
+	}
+	return table.Flush()
+}
+
+//typeNames converts BlacklistedEntryTypes to strings
+func typeNames(types []list.BlacklistedEntryType) []string {
+	names := make([]string, len(types))
+	for i, entryType := range types {
+		names[i] = string(entryType)
+	}
+	return names
+}
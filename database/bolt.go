@@ -0,0 +1,635 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+	bolt "go.etcd.io/bbolt"
+)
+
+//boltDB provides an embedded, on-disk backend for rita-blacklist.
+//The lists registry is held in the listsCollection bucket. Entries are
+//held in one bucket per BlacklistedEntryType. Each entry bucket contains
+//a nested bucket per list. A list bucket holds its active generation under
+//boltGenerationKey and a nested bucket per generation which maps indexes
+//to the JSON encoded ExtraData of the entry. Staged entries are written
+//to the generation following the active generation, so committing them
+//only requires bumping the active generation. Bloom filters are held in the
+//filtersCollection bucket, in a nested bucket per list which maps entry
+//types to encoded filters. Cached RPC results are held in the
+//rpcCacheCollection bucket, in a nested bucket per cache name.
+type boltDB struct {
+	db *bolt.DB
+}
+
+//boltGenerationKey holds the active generation of a list bucket
+var boltGenerationKey = []byte("generation")
+
+//NewBoltDB returns a new Handle backed by a BoltDB file at the given path.
+//The file is created if it does not exist. The returned Handle implements
+//io.Closer, and it should be closed in order to release the file lock.
+//Note: ExtraData is stored as JSON, so numeric values are returned as float64.
+func NewBoltDB(path string) (Handle, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(listsCollection))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(filtersCollection))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(rpcCacheCollection))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltDB{db: db}, nil
+}
+
+//Close closes the underlying BoltDB file
+func (b *boltDB) Close() error {
+	return b.db.Close()
+}
+
+//GetRegisteredLists retrieves all of the lists registered with the database
+func (b *boltDB) GetRegisteredLists(ctx context.Context) ([]list.Metadata, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var lists []list.Metadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(listsCollection)).ForEach(func(k, v []byte) error {
+			var meta list.Metadata
+			err := json.Unmarshal(v, &meta)
+			if err != nil {
+				return err
+			}
+			lists = append(lists, meta)
+			return nil
+		})
+	})
+	return lists, err
+}
+
+//RegisterList registers a new blacklist source with the database
+func (b *boltDB) RegisterList(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	encodedMeta, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		listsBucket := tx.Bucket([]byte(listsCollection))
+		if listsBucket.Get([]byte(l.Name)) != nil {
+			return fmt.Errorf("list %s is already registered", l.Name)
+		}
+		err := listsBucket.Put([]byte(l.Name), encodedMeta)
+		if err != nil {
+			return err
+		}
+
+		//create the buckets for the types of entries this list produces
+		for _, entryType := range l.Types {
+			typeBucket, err := tx.CreateBucketIfNotExists([]byte(entryType))
+			if err != nil {
+				return err
+			}
+			_, err = typeBucket.CreateBucketIfNotExists([]byte(l.Name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//RemoveList removes an existing blacklist source from the database
+func (b *boltDB) RemoveList(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := clearBoltCache(tx, l)
+		if err != nil {
+			return err
+		}
+		listsBucket := tx.Bucket([]byte(listsCollection))
+		if listsBucket.Get([]byte(l.Name)) == nil {
+			return fmt.Errorf("list %s is not registered", l.Name)
+		}
+		err = deleteBoltFilters(tx, l.Name)
+		if err != nil {
+			return err
+		}
+		return listsBucket.Delete([]byte(l.Name))
+	})
+}
+
+//UpdateListMetadata updates the metadata of an existing blacklist
+func (b *boltDB) UpdateListMetadata(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	encodedMeta, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		listsBucket := tx.Bucket([]byte(listsCollection))
+		if listsBucket.Get([]byte(l.Name)) == nil {
+			return fmt.Errorf("list %s is not registered", l.Name)
+		}
+		return listsBucket.Put([]byte(l.Name), encodedMeta)
+	})
+}
+
+//ClearCache clears old entries for a given list
+func (b *boltDB) ClearCache(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return clearBoltCache(tx, l)
+	})
+}
+
+//clearBoltCache removes the entry buckets for a given list
+func clearBoltCache(tx *bolt.Tx, l list.Metadata) error {
+	for _, entryType := range l.Types {
+		typeBucket := tx.Bucket([]byte(entryType))
+		if typeBucket == nil || typeBucket.Bucket([]byte(l.Name)) == nil {
+			continue
+		}
+		err := typeBucket.DeleteBucket([]byte(l.Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//InsertEntries inserts entries from a list into the database
+func (b *boltDB) InsertEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	b.writeEntries(ctx, entryType, entries, false, wg, errorsOut)
+}
+
+//StageEntries inserts entries from a list into the list's staging area
+func (b *boltDB) StageEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	b.writeEntries(ctx, entryType, entries, true, wg, errorsOut)
+}
+
+//CommitStagedEntries atomically replaces the entries of a list with
+//the entries held in its staging area
+func (b *boltDB) CommitStagedEntries(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, entryType := range l.Types {
+			typeBucket, err := tx.CreateBucketIfNotExists([]byte(entryType))
+			if err != nil {
+				return err
+			}
+			listBucket, err := typeBucket.CreateBucketIfNotExists([]byte(l.Name))
+			if err != nil {
+				return err
+			}
+			generation := boltActiveGeneration(listBucket)
+			activeName := boltGenerationName(generation)
+			if listBucket.Bucket(activeName) != nil {
+				err = listBucket.DeleteBucket(activeName)
+				if err != nil {
+					return err
+				}
+			}
+			//a list which staged no entries of a type no longer holds any
+			err = listBucket.Put(boltGenerationKey, boltGenerationName(generation+1))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//DiscardStagedEntries removes the entries held in a list's staging area
+func (b *boltDB) DiscardStagedEntries(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, entryType := range l.Types {
+			typeBucket := tx.Bucket([]byte(entryType))
+			if typeBucket == nil {
+				continue
+			}
+			listBucket := typeBucket.Bucket([]byte(l.Name))
+			if listBucket == nil {
+				continue
+			}
+			stagedName := boltGenerationName(boltActiveGeneration(listBucket) + 1)
+			if listBucket.Bucket(stagedName) == nil {
+				continue
+			}
+			err := listBucket.DeleteBucket(stagedName)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//GetListEntries returns the entries of a given type held by a list
+func (b *boltDB) GetListEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType) ([]BlacklistResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var entries []BlacklistResult
+	err := b.db.View(func(tx *bolt.Tx) error {
+		generationBucket := boltActiveBucket(tx, entryType, l.Name)
+		if generationBucket == nil {
+			return nil
+		}
+		return generationBucket.ForEach(func(k, v []byte) error {
+			result := BlacklistResult{Index: string(k), List: l.Name}
+			err := json.Unmarshal(v, &result.ExtraData)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, result)
+			//bolt can't be interrupted, so check ctx periodically
+			if len(entries)%100000 == 0 {
+				return ctx.Err()
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+//UpdateEntries inserts the added entries into a list and removes the
+//entries with the removed indexes from the list in a single transaction
+func (b *boltDB) UpdateEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType,
+	added []list.BlacklistedEntry, removed []string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		typeBucket, err := tx.CreateBucketIfNotExists([]byte(entryType))
+		if err != nil {
+			return err
+		}
+		listBucket, err := typeBucket.CreateBucketIfNotExists([]byte(l.Name))
+		if err != nil {
+			return err
+		}
+		generationBucket, err := listBucket.CreateBucketIfNotExists(
+			boltGenerationName(boltActiveGeneration(listBucket)),
+		)
+		if err != nil {
+			return err
+		}
+		for _, index := range removed {
+			err = generationBucket.Delete([]byte(index))
+			if err != nil {
+				return err
+			}
+		}
+		for _, entry := range added {
+			encodedData, err := json.Marshal(entry.ExtraData)
+			if err != nil {
+				return err
+			}
+			err = generationBucket.Put([]byte(entry.Index), encodedData)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//writeEntries writes entries to either the active or the staged generation
+//of their lists in batches. If ctx is cancelled, the remaining entries
+//are discarded.
+func (b *boltDB) writeEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, staged bool,
+	wg *sync.WaitGroup, errorsOut chan<- error) {
+	defer wg.Done()
+
+	buffSize := 100000
+	buffer := make([]list.BlacklistedEntry, 0, buffSize)
+	discarded := 0
+	for entry := range entries {
+		if discarded > 0 || ctx.Err() != nil {
+			discarded++
+			continue
+		}
+		buffer = append(buffer, entry)
+		if len(buffer) == buffSize {
+			for _, err := range b.writeBatch(entryType, buffer, staged) {
+				errorsOut <- err
+			}
+			buffer = buffer[:0]
+		}
+	}
+	if discarded > 0 {
+		//the buffered entries are discarded as well
+		errorsOut <- &WriteError{Entries: discarded + len(buffer), Err: ctx.Err()}
+		return
+	}
+	if len(buffer) != 0 {
+		for _, err := range b.writeBatch(entryType, buffer, staged) {
+			errorsOut <- err
+		}
+	}
+}
+
+//writeBatch writes a set of entries in a single transaction. Entries which
+//already exist in their list are skipped and reported in the returned errors.
+func (b *boltDB) writeBatch(entryType list.BlacklistedEntryType,
+	batch []list.BlacklistedEntry, staged bool) []error {
+	var errs []error
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		errs = nil
+		typeBucket, err := tx.CreateBucketIfNotExists([]byte(entryType))
+		if err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			listName := entry.List.GetMetadata().Name
+			listBucket, err := typeBucket.CreateBucketIfNotExists([]byte(listName))
+			if err != nil {
+				return err
+			}
+			generation := boltActiveGeneration(listBucket)
+			if staged {
+				generation++
+			}
+			generationBucket, err := listBucket.CreateBucketIfNotExists(
+				boltGenerationName(generation),
+			)
+			if err != nil {
+				return err
+			}
+			//mirror the unique (index, list) constraint of the mongo backend
+			if generationBucket.Get([]byte(entry.Index)) != nil {
+				errs = append(errs, &WriteError{Entries: 1, Err: fmt.Errorf(
+					"duplicate %s entry %s in list %s", entryType, entry.Index, listName,
+				)})
+				continue
+			}
+			encodedData, err := json.Marshal(entry.ExtraData)
+			if err != nil {
+				return err
+			}
+			err = generationBucket.Put([]byte(entry.Index), encodedData)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	//the transaction is rolled back, so none of the entries were written
+	if err != nil {
+		return []error{&WriteError{Entries: len(batch), Err: err}}
+	}
+	return errs
+}
+
+//FindEntries finds entries of a given type and index
+func (b *boltDB) FindEntries(ctx context.Context, dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error) {
+	results, err := b.FindEntriesBatch(ctx, dataType, []string{index})
+	return results[index], err
+}
+
+//FindEntriesBatch finds the entries of a given type for many indexes
+//within a single read transaction
+func (b *boltDB) FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+	indexes []string) (map[string][]BlacklistResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	results := make(map[string][]BlacklistResult)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		typeBucket := tx.Bucket([]byte(dataType))
+		if typeBucket == nil {
+			return nil
+		}
+		//nested buckets are returned with a nil value
+		return typeBucket.ForEach(func(listName, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			generationBucket := boltActiveBucket(tx, dataType, string(listName))
+			if generationBucket == nil {
+				return nil
+			}
+			found := make(map[string]bool)
+			for _, index := range indexes {
+				if found[index] {
+					continue
+				}
+				encodedData := generationBucket.Get([]byte(index))
+				if encodedData == nil {
+					continue
+				}
+				found[index] = true
+				result := BlacklistResult{
+					Index: index,
+					List:  string(listName),
+				}
+				err := json.Unmarshal(encodedData, &result.ExtraData)
+				if err != nil {
+					return err
+				}
+				results[index] = append(results[index], result)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+//GetFilters returns the encoded bloom filters stored for a list
+//by entry type
+func (b *boltDB) GetFilters(ctx context.Context, l list.Metadata) (map[list.BlacklistedEntryType][]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	filters := make(map[list.BlacklistedEntryType][]byte)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		listBucket := tx.Bucket([]byte(filtersCollection)).Bucket([]byte(l.Name))
+		if listBucket == nil {
+			return nil
+		}
+		return listBucket.ForEach(func(k, v []byte) error {
+			//values are only valid during the transaction
+			filter := make([]byte, len(v))
+			copy(filter, v)
+			filters[list.BlacklistedEntryType(k)] = filter
+			return nil
+		})
+	})
+	return filters, err
+}
+
+//SetFilters replaces the encoded bloom filters stored for a list
+func (b *boltDB) SetFilters(ctx context.Context, l list.Metadata, filters map[list.BlacklistedEntryType][]byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := deleteBoltFilters(tx, l.Name)
+		if err != nil || len(filters) == 0 {
+			return err
+		}
+		listBucket, err := tx.Bucket([]byte(filtersCollection)).CreateBucket([]byte(l.Name))
+		if err != nil {
+			return err
+		}
+		for entryType, filter := range filters {
+			err = listBucket.Put([]byte(entryType), filter)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//deleteBoltFilters removes the bloom filters stored for a given list
+func deleteBoltFilters(tx *bolt.Tx, listName string) error {
+	filtersBucket := tx.Bucket([]byte(filtersCollection))
+	if filtersBucket.Bucket([]byte(listName)) == nil {
+		return nil
+	}
+	return filtersBucket.DeleteBucket([]byte(listName))
+}
+
+//LoadRPCCache retrieves the results stored under the given cache name
+func (b *boltDB) LoadRPCCache(ctx context.Context, name string) ([]CachedResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var results []CachedResult
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cacheBucket := tx.Bucket([]byte(rpcCacheCollection)).Bucket([]byte(name))
+		if cacheBucket == nil {
+			return nil
+		}
+		return cacheBucket.ForEach(func(k, v []byte) error {
+			var result CachedResult
+			err := json.Unmarshal(v, &result)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+			return nil
+		})
+	})
+	return results, err
+}
+
+//StoreRPCCache stores results under the given cache name
+func (b *boltDB) StoreRPCCache(ctx context.Context, name string, results []CachedResult) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		cacheBucket, err := tx.Bucket([]byte(rpcCacheCollection)).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			encodedResult, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			err = cacheBucket.Put([]byte(result.Index), encodedResult)
+			if err != nil {
+				return err
+			}
+		}
+
+		//remove the expired results. Keys must not be deleted while
+		//iterating, so they are collected first.
+		now := time.Now().Unix()
+		var expired [][]byte
+		err = cacheBucket.ForEach(func(k, v []byte) error {
+			var result CachedResult
+			err := json.Unmarshal(v, &result)
+			if err != nil {
+				return err
+			}
+			if result.Expires <= now {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			err = cacheBucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//boltActiveBucket returns the bucket holding the visible entries of a
+//given type for a list, or nil if the list holds no entries of the type
+func boltActiveBucket(tx *bolt.Tx, entryType list.BlacklistedEntryType, listName string) *bolt.Bucket {
+	typeBucket := tx.Bucket([]byte(entryType))
+	if typeBucket == nil {
+		return nil
+	}
+	listBucket := typeBucket.Bucket([]byte(listName))
+	if listBucket == nil {
+		return nil
+	}
+	return listBucket.Bucket(boltGenerationName(boltActiveGeneration(listBucket)))
+}
+
+//boltActiveGeneration returns the generation of entries which are
+//visible in a given list bucket
+func boltActiveGeneration(listBucket *bolt.Bucket) uint64 {
+	encodedGeneration := listBucket.Get(boltGenerationKey)
+	if encodedGeneration == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(encodedGeneration)
+}
+
+//boltGenerationName returns the key used to store a generation
+func boltGenerationName(generation uint64) []byte {
+	name := make([]byte, 8)
+	binary.BigEndian.PutUint64(name, generation)
+	return name
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltDB(t *testing.T) Handle {
+	db, err := NewBoltDB(filepath.Join(t.TempDir(), "rita-bl.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { db.(io.Closer).Close() })
+	return db
+}
+
+func TestBoltDB(t *testing.T) {
+	t.Run("Lists", func(t *testing.T) { testHandleLists(t, newTestBoltDB(t)) })
+	t.Run("Entries", func(t *testing.T) { testHandleEntries(t, newTestBoltDB(t)) })
+	t.Run("Staging", func(t *testing.T) { testHandleStaging(t, newTestBoltDB(t)) })
+	t.Run("UpdateEntries", func(t *testing.T) { testHandleUpdateEntries(t, newTestBoltDB(t)) })
+	t.Run("Filters", func(t *testing.T) { testHandleFilters(t, newTestBoltDB(t)) })
+	t.Run("RPCCache", func(t *testing.T) { testHandleRPCCache(t, newTestBoltDB(t)) })
+}
+
+func TestBoltDBReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rita-bl.db")
+	db, err := NewBoltDB(path)
+	require.Nil(t, err)
+
+	source := newTestList("a")
+	require.Nil(t, db.RegisterList(ctx, source.GetMetadata()))
+	assert.Len(t, insertTestEntries(db, source, "1.1.1.1"), 0)
+	require.Nil(t, db.(io.Closer).Close())
+
+	//the registry and entries must survive a restart
+	db, err = NewBoltDB(path)
+	require.Nil(t, err)
+	defer db.(io.Closer).Close()
+
+	metas, err := db.GetRegisteredLists(ctx)
+	require.Nil(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, source.GetMetadata(), metas[0])
+
+	results, err := db.FindEntries(ctx, list.BlacklistedIPType, "1.1.1.1")
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].List)
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"sync"
 
 	"github.com/activecm/rita-bl/list"
@@ -8,32 +9,85 @@ import (
 
 type (
 	//Handle provides an interface for using a databae to hold
-	//blacklist information
+	//blacklist information. Implementations should stop their work and
+	//return ctx.Err() once the supplied context is cancelled.
 	Handle interface {
 		//GetRegisteredLists retrieves all of the lists registered with the database
-		GetRegisteredLists() ([]list.Metadata, error)
+		GetRegisteredLists(ctx context.Context) ([]list.Metadata, error)
 
 		//RegisterList registers a new blacklist source with the database
-		RegisterList(list.Metadata) error
+		RegisterList(ctx context.Context, l list.Metadata) error
 
-		//RemoveList removes an existing blacklist source from the database
-		RemoveList(list.Metadata) error
+		//RemoveList removes an existing blacklist source, along with its
+		//entries and bloom filters, from the database
+		RemoveList(ctx context.Context, l list.Metadata) error
 
 		//UpdateListMetadata updates the metadata of an existing blacklist
-		UpdateListMetadata(list.Metadata) error
+		UpdateListMetadata(ctx context.Context, l list.Metadata) error
 
 		//ClearCache clears old entries for a given list
-		ClearCache(list.Metadata) error
+		ClearCache(ctx context.Context, l list.Metadata) error
 
-		//InsertEntries inserts entries from a list into the database
+		//InsertEntries inserts entries from a list into the database.
+		//Entries which are not written are reported with a WriteError.
 		InsertEntries(
+			ctx context.Context,
 			entryType list.BlacklistedEntryType,
 			entries <-chan list.BlacklistedEntry,
 			wg *sync.WaitGroup, errorsOut chan<- error,
 		)
 
+		//StageEntries inserts entries from a list into the list's staging area.
+		//Staged entries are not returned by FindEntries until
+		//CommitStagedEntries is called for the list. Entries which are
+		//not written are reported with a WriteError.
+		StageEntries(
+			ctx context.Context,
+			entryType list.BlacklistedEntryType,
+			entries <-chan list.BlacklistedEntry,
+			wg *sync.WaitGroup, errorsOut chan<- error,
+		)
+
+		//CommitStagedEntries atomically replaces the entries of a list with
+		//the entries held in its staging area
+		CommitStagedEntries(ctx context.Context, l list.Metadata) error
+
+		//DiscardStagedEntries removes the entries held in a list's staging area
+		DiscardStagedEntries(ctx context.Context, l list.Metadata) error
+
+		//GetListEntries returns the entries of a given type held by a list
+		GetListEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType) ([]BlacklistResult, error)
+
+		//UpdateEntries inserts the added entries into a list and removes the
+		//entries with the removed indexes from the list. An entry is replaced
+		//by removing and adding its index. Backends which support transactions
+		//apply the changes atomically.
+		UpdateEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType,
+			added []list.BlacklistedEntry, removed []string) error
+
+		//GetFilters returns the encoded bloom filters stored for a list
+		//by entry type
+		GetFilters(ctx context.Context, l list.Metadata) (map[list.BlacklistedEntryType][]byte, error)
+
+		//SetFilters replaces the encoded bloom filters stored for a list.
+		//Passing nil removes the list's filters.
+		SetFilters(ctx context.Context, l list.Metadata, filters map[list.BlacklistedEntryType][]byte) error
+
 		//FindEntries finds entries of a given type and index
-		FindEntries(dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error)
+		FindEntries(ctx context.Context, dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error)
+
+		//FindEntriesBatch finds the entries of a given type for many indexes
+		//at once and returns them by index. Indexes without any entries
+		//are left out of the returned map.
+		FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+			indexes []string) (map[string][]BlacklistResult, error)
+
+		//LoadRPCCache retrieves the results stored under the given cache name
+		LoadRPCCache(ctx context.Context, name string) ([]CachedResult, error)
+
+		//StoreRPCCache stores results under the given cache name, replacing
+		//any results with the same index. Expired results are removed.
+		StoreRPCCache(ctx context.Context, name string, results []CachedResult) error
 	}
 
 	//BlacklistResult is the database safe version of BlacklistedEntry.
@@ -47,4 +101,36 @@ type (
 		//ExtraData contains extra information this blacklist source provides
 		ExtraData map[string]interface{}
 	}
+
+	//WriteError is sent by InsertEntries and StageEntries when entries
+	//could not be written, e.g. duplicates or a failed batch
+	WriteError struct {
+		//Entries is the number of entries which were not written
+		Entries int
+		//Err is the reason the entries were not written
+		Err error
+	}
+
+	//CachedResult is the outcome of checking an index against an RPC,
+	//stored so the check doesn't need to be repeated
+	CachedResult struct {
+		//Index is the index which was checked
+		Index string
+		//Found is true if the RPC returned a result for the index
+		Found bool
+		//Result holds the result returned by the RPC if Found is true
+		Result BlacklistResult
+		//Expires is the unix timestamp at which the result expires
+		Expires int64
+	}
 )
+
+//Error implements the error interface
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+//Unwrap returns the underlying error
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
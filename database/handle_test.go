@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+type testList struct {
+	meta list.Metadata
+}
+
+func (t *testList) GetMetadata() list.Metadata { return t.meta }
+
+func (t *testList) SetMetadata(m list.Metadata) { t.meta = m }
+
+func (t *testList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	for _, entryChannel := range entryMap {
+		close(entryChannel)
+	}
+}
+
+func newTestList(name string) *testList {
+	return &testList{
+		meta: list.Metadata{
+			Name:      name,
+			Types:     []list.BlacklistedEntryType{list.BlacklistedIPType},
+			CacheTime: 86400,
+		},
+	}
+}
+
+func insertTestEntries(db Handle, source list.List, indexes ...string) []error {
+	return writeTestEntries(db.InsertEntries, source, indexes...)
+}
+
+func stageTestEntries(db Handle, source list.List, indexes ...string) []error {
+	return writeTestEntries(db.StageEntries, source, indexes...)
+}
+
+func writeTestEntries(
+	write func(context.Context, list.BlacklistedEntryType,
+		<-chan list.BlacklistedEntry, *sync.WaitGroup, chan<- error),
+	source list.List, indexes ...string) []error {
+	entries := make(chan list.BlacklistedEntry)
+	errorsOut := make(chan error, len(indexes))
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go write(ctx, list.BlacklistedIPType, entries, wg, errorsOut)
+	for _, index := range indexes {
+		entries <- list.NewBlacklistedEntry(index, source)
+	}
+	close(entries)
+	wg.Wait()
+	close(errorsOut)
+
+	var errs []error
+	for err := range errorsOut {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+//testHandleLists runs the list registry tests against a given Handle
+func testHandleLists(t *testing.T, db Handle) {
+	listA := newTestList("a")
+
+	require.Nil(t, db.RegisterList(ctx, listA.GetMetadata()))
+	assert.NotNil(t, db.RegisterList(ctx, listA.GetMetadata()))
+
+	metas, err := db.GetRegisteredLists(ctx)
+	require.Nil(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, "a", metas[0].Name)
+
+	updated := listA.GetMetadata()
+	updated.LastUpdate = 100
+	require.Nil(t, db.UpdateListMetadata(ctx, updated))
+	metas, err = db.GetRegisteredLists(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, int64(100), metas[0].LastUpdate)
+
+	assert.NotNil(t, db.UpdateListMetadata(ctx, newTestList("b").GetMetadata()))
+
+	require.Nil(t, db.RemoveList(ctx, listA.GetMetadata()))
+	assert.NotNil(t, db.RemoveList(ctx, listA.GetMetadata()))
+	metas, err = db.GetRegisteredLists(ctx)
+	require.Nil(t, err)
+	assert.Len(t, metas, 0)
+}
+
+//testHandleEntries runs the entry storage tests against a given Handle
+func testHandleEntries(t *testing.T, db Handle) {
+	listA := newTestList("a")
+	listB := newTestList("b")
+	require.Nil(t, db.RegisterList(ctx, listA.GetMetadata()))
+	require.Nil(t, db.RegisterList(ctx, listB.GetMetadata()))
+
+	assert.Len(t, insertTestEntries(db, listA, "1.1.1.1", "2.2.2.2"), 0)
+	assert.Len(t, insertTestEntries(db, listB, "1.1.1.1"), 0)
+	//duplicate entries within a list are rejected
+	errs := insertTestEntries(db, listB, "1.1.1.1", "3.3.3.3")
+	require.Len(t, errs, 1)
+	var writeErr *WriteError
+	require.True(t, errors.As(errs[0], &writeErr))
+	assert.Equal(t, 1, writeErr.Entries)
+	assert.Len(t, findTestIndexes(t, db, "3.3.3.3"), 1)
+	require.Nil(t, db.UpdateEntries(ctx, listB.GetMetadata(), list.BlacklistedIPType, nil, []string{"3.3.3.3"}))
+
+	results, err := db.FindEntries(ctx, list.BlacklistedIPType, "1.1.1.1")
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].List)
+	assert.Equal(t, "b", results[1].List)
+
+	results, err = db.FindEntries(ctx, list.BlacklistedHostnameType, "1.1.1.1")
+	require.Nil(t, err)
+	assert.Len(t, results, 0)
+
+	batch, err := db.FindEntriesBatch(ctx, list.BlacklistedIPType,
+		[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "1.1.1.1"})
+	require.Nil(t, err)
+	require.Len(t, batch, 2)
+	require.Len(t, batch["1.1.1.1"], 2)
+	assert.Equal(t, "a", batch["1.1.1.1"][0].List)
+	assert.Equal(t, "b", batch["1.1.1.1"][1].List)
+	require.Len(t, batch["2.2.2.2"], 1)
+	assert.Equal(t, "a", batch["2.2.2.2"][0].List)
+
+	require.Nil(t, db.ClearCache(ctx, listA.GetMetadata()))
+	results, err = db.FindEntries(ctx, list.BlacklistedIPType, "2.2.2.2")
+	require.Nil(t, err)
+	assert.Len(t, results, 0)
+
+	require.Nil(t, db.RemoveList(ctx, listB.GetMetadata()))
+	results, err = db.FindEntries(ctx, list.BlacklistedIPType, "1.1.1.1")
+	require.Nil(t, err)
+	assert.Len(t, results, 0)
+}
+
+func findTestIndexes(t *testing.T, db Handle, indexes ...string) []string {
+	var found []string
+	for _, index := range indexes {
+		results, err := db.FindEntries(ctx, list.BlacklistedIPType, index)
+		require.Nil(t, err)
+		for _, result := range results {
+			found = append(found, result.Index)
+		}
+	}
+	return found
+}
+
+//testHandleStaging runs the staging tests against a given Handle
+func testHandleStaging(t *testing.T, db Handle) {
+	listA := newTestList("a")
+	require.Nil(t, db.RegisterList(ctx, listA.GetMetadata()))
+	assert.Len(t, insertTestEntries(db, listA, "1.1.1.1", "2.2.2.2"), 0)
+
+	//staged entries are invisible until committed
+	assert.Len(t, stageTestEntries(db, listA, "2.2.2.2", "3.3.3.3"), 0)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"},
+		findTestIndexes(t, db, "1.1.1.1", "2.2.2.2", "3.3.3.3"))
+
+	require.Nil(t, db.CommitStagedEntries(ctx, listA.GetMetadata()))
+	assert.Equal(t, []string{"2.2.2.2", "3.3.3.3"},
+		findTestIndexes(t, db, "1.1.1.1", "2.2.2.2", "3.3.3.3"))
+
+	//discarded entries never become visible
+	assert.Len(t, stageTestEntries(db, listA, "4.4.4.4"), 0)
+	require.Nil(t, db.DiscardStagedEntries(ctx, listA.GetMetadata()))
+	require.Nil(t, db.CommitStagedEntries(ctx, listA.GetMetadata()))
+	assert.Len(t, findTestIndexes(t, db, "2.2.2.2", "3.3.3.3", "4.4.4.4"), 0)
+
+	//live inserts after a commit are visible
+	assert.Len(t, insertTestEntries(db, listA, "5.5.5.5"), 0)
+	assert.Equal(t, []string{"5.5.5.5"}, findTestIndexes(t, db, "5.5.5.5"))
+}
+
+//testHandleUpdateEntries runs the incremental update tests against a given Handle
+func testHandleUpdateEntries(t *testing.T, db Handle) {
+	listA := newTestList("a")
+	meta := listA.GetMetadata()
+	require.Nil(t, db.RegisterList(ctx, meta))
+	assert.Len(t, insertTestEntries(db, listA, "1.1.1.1", "2.2.2.2"), 0)
+
+	assert.ElementsMatch(t, []string{"1.1.1.1", "2.2.2.2"}, listTestIndexes(t, db, meta))
+
+	added := []list.BlacklistedEntry{list.NewBlacklistedEntry("3.3.3.3", listA)}
+	require.Nil(t, db.UpdateEntries(ctx, meta, list.BlacklistedIPType, added, []string{"1.1.1.1"}))
+	assert.Equal(t, []string{"2.2.2.2", "3.3.3.3"},
+		findTestIndexes(t, db, "1.1.1.1", "2.2.2.2", "3.3.3.3"))
+
+	assert.ElementsMatch(t, []string{"2.2.2.2", "3.3.3.3"}, listTestIndexes(t, db, meta))
+
+	//an entry is replaced by removing and adding its index
+	changed := list.NewBlacklistedEntry("2.2.2.2", listA)
+	changed.ExtraData = map[string]interface{}{"port": "443"}
+	require.Nil(t, db.UpdateEntries(ctx, meta, list.BlacklistedIPType,
+		[]list.BlacklistedEntry{changed}, []string{"2.2.2.2"}))
+	entries, err := db.GetListEntries(ctx, meta, list.BlacklistedIPType)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, "a", entry.List)
+		if entry.Index == "2.2.2.2" {
+			assert.Equal(t, changed.ExtraData, entry.ExtraData)
+		} else {
+			assert.Len(t, entry.ExtraData, 0)
+		}
+	}
+	require.Nil(t, db.UpdateEntries(ctx, meta, list.BlacklistedIPType, nil, []string{"2.2.2.2"}))
+
+	//updates apply to the visible entries after a commit
+	assert.Len(t, stageTestEntries(db, listA, "4.4.4.4"), 0)
+	require.Nil(t, db.CommitStagedEntries(ctx, meta))
+	require.Nil(t, db.UpdateEntries(ctx, meta, list.BlacklistedIPType, added, []string{"4.4.4.4"}))
+	assert.Equal(t, []string{"3.3.3.3"}, listTestIndexes(t, db, meta))
+}
+
+func listTestIndexes(t *testing.T, db Handle, meta list.Metadata) []string {
+	entries, err := db.GetListEntries(ctx, meta, list.BlacklistedIPType)
+	require.Nil(t, err)
+	var indexes []string
+	for _, entry := range entries {
+		indexes = append(indexes, entry.Index)
+	}
+	return indexes
+}
+
+//testHandleFilters runs the bloom filter storage tests against a given Handle
+func testHandleFilters(t *testing.T, db Handle) {
+	listA := newTestList("a")
+	meta := listA.GetMetadata()
+	require.Nil(t, db.RegisterList(ctx, meta))
+
+	filters, err := db.GetFilters(ctx, meta)
+	require.Nil(t, err)
+	assert.Len(t, filters, 0)
+
+	stored := map[list.BlacklistedEntryType][]byte{list.BlacklistedIPType: []byte("filter")}
+	require.Nil(t, db.SetFilters(ctx, meta, stored))
+	filters, err = db.GetFilters(ctx, meta)
+	require.Nil(t, err)
+	assert.Equal(t, stored, filters)
+
+	//filters are kept apart from the metadata
+	require.Nil(t, db.UpdateListMetadata(ctx, meta))
+	filters, err = db.GetFilters(ctx, meta)
+	require.Nil(t, err)
+	assert.Equal(t, stored, filters)
+
+	//removing the filters or the list removes the stored filters
+	require.Nil(t, db.SetFilters(ctx, meta, nil))
+	filters, err = db.GetFilters(ctx, meta)
+	require.Nil(t, err)
+	assert.Len(t, filters, 0)
+	require.Nil(t, db.SetFilters(ctx, meta, stored))
+	require.Nil(t, db.RemoveList(ctx, meta))
+	filters, err = db.GetFilters(ctx, meta)
+	require.Nil(t, err)
+	assert.Len(t, filters, 0)
+}
+
+//testHandleRPCCache runs the RPC cache tests against a given Handle
+func testHandleRPCCache(t *testing.T, db Handle) {
+	expires := time.Now().Add(time.Hour).Unix()
+	require.Nil(t, db.StoreRPCCache(ctx, "a", []CachedResult{
+		{Index: "1.1.1.1", Found: true, Expires: expires, Result: BlacklistResult{Index: "1.1.1.1", List: "rpc"}},
+		{Index: "2.2.2.2", Expires: expires},
+	}))
+	//expired results are removed and replaced results are overwritten
+	require.Nil(t, db.StoreRPCCache(ctx, "a", []CachedResult{
+		{Index: "2.2.2.2", Expires: time.Now().Add(-time.Hour).Unix()},
+	}))
+
+	results, err := db.LoadRPCCache(ctx, "a")
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "1.1.1.1", results[0].Index)
+	assert.True(t, results[0].Found)
+	assert.Equal(t, "rpc", results[0].Result.List)
+	assert.Equal(t, expires, results[0].Expires)
+
+	//caches are kept apart by name
+	results, err = db.LoadRPCCache(ctx, "b")
+	require.Nil(t, err)
+	assert.Len(t, results, 0)
+}
@@ -0,0 +1,409 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+//memoryDB provides an in-memory backend for rita-blacklist.
+//Entries are stored by type, then by list, then by index so that
+//clearing a list does not require scanning every entry of a given type.
+//Staged entries are held in a separate store with the same layout.
+//Bloom filters are held by list.
+type memoryDB struct {
+	mutex   *sync.RWMutex
+	lists   map[string]list.Metadata
+	entries map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult
+	staging map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult
+	filters map[string]map[list.BlacklistedEntryType][]byte
+	rpcs    map[string]map[string]CachedResult
+}
+
+//NewMemoryDB returns a new in-memory Handle. The data held by the Handle
+//is lost once the Handle is garbage collected.
+func NewMemoryDB() Handle {
+	return &memoryDB{
+		mutex:   new(sync.RWMutex),
+		lists:   make(map[string]list.Metadata),
+		entries: make(map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult),
+		staging: make(map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult),
+		filters: make(map[string]map[list.BlacklistedEntryType][]byte),
+		rpcs:    make(map[string]map[string]CachedResult),
+	}
+}
+
+//GetRegisteredLists retrieves all of the lists registered with the database
+func (m *memoryDB) GetRegisteredLists(ctx context.Context) ([]list.Metadata, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	lists := make([]list.Metadata, 0, len(m.lists))
+	for _, meta := range m.lists {
+		lists = append(lists, copyMetadata(meta))
+	}
+	//return the lists in a stable order
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Name < lists[j].Name
+	})
+	return lists, nil
+}
+
+//RegisterList registers a new blacklist source with the database
+func (m *memoryDB) RegisterList(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.lists[l.Name]; ok {
+		return fmt.Errorf("list %s is already registered", l.Name)
+	}
+	m.lists[l.Name] = copyMetadata(l)
+
+	//create the stores for the types of entries this list produces
+	for _, entryType := range l.Types {
+		if _, ok := m.entries[entryType]; !ok {
+			m.entries[entryType] = make(map[string]map[string]BlacklistResult)
+		}
+	}
+	return nil
+}
+
+//RemoveList removes an existing blacklist source from the database
+func (m *memoryDB) RemoveList(ctx context.Context, l list.Metadata) error {
+	err := m.ClearCache(ctx, l)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.lists[l.Name]; !ok {
+		return fmt.Errorf("list %s is not registered", l.Name)
+	}
+	delete(m.lists, l.Name)
+	delete(m.filters, l.Name)
+	return nil
+}
+
+//UpdateListMetadata updates the metadata of an existing blacklist
+func (m *memoryDB) UpdateListMetadata(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.lists[l.Name]; !ok {
+		return fmt.Errorf("list %s is not registered", l.Name)
+	}
+	m.lists[l.Name] = copyMetadata(l)
+	return nil
+}
+
+//ClearCache clears old entries for a given list
+func (m *memoryDB) ClearCache(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, entryType := range l.Types {
+		if typeStore, ok := m.entries[entryType]; ok {
+			delete(typeStore, l.Name)
+		}
+		if typeStore, ok := m.staging[entryType]; ok {
+			delete(typeStore, l.Name)
+		}
+	}
+	return nil
+}
+
+//InsertEntries inserts entries from a list into the database
+func (m *memoryDB) InsertEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	m.writeEntries(ctx, m.entries, entryType, entries, wg, errorsOut)
+}
+
+//StageEntries inserts entries from a list into the list's staging area
+func (m *memoryDB) StageEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	m.writeEntries(ctx, m.staging, entryType, entries, wg, errorsOut)
+}
+
+//CommitStagedEntries atomically replaces the entries of a list with
+//the entries held in its staging area
+func (m *memoryDB) CommitStagedEntries(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, entryType := range l.Types {
+		typeStore, ok := m.entries[entryType]
+		if !ok {
+			typeStore = make(map[string]map[string]BlacklistResult)
+			m.entries[entryType] = typeStore
+		}
+		//a list which staged no entries of a type no longer holds any
+		delete(typeStore, l.Name)
+		if stagedStore, ok := m.staging[entryType][l.Name]; ok {
+			typeStore[l.Name] = stagedStore
+			delete(m.staging[entryType], l.Name)
+		}
+	}
+	return nil
+}
+
+//DiscardStagedEntries removes the entries held in a list's staging area
+func (m *memoryDB) DiscardStagedEntries(ctx context.Context, l list.Metadata) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, entryType := range l.Types {
+		if typeStore, ok := m.staging[entryType]; ok {
+			delete(typeStore, l.Name)
+		}
+	}
+	return nil
+}
+
+//GetListEntries returns the entries of a given type held by a list
+func (m *memoryDB) GetListEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType) ([]BlacklistResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	listStore := m.entries[entryType][l.Name]
+	entries := make([]BlacklistResult, 0, len(listStore))
+	for _, result := range listStore {
+		entries = append(entries, result)
+	}
+	return entries, nil
+}
+
+//UpdateEntries inserts the added entries into a list and removes the
+//entries with the removed indexes from the list
+func (m *memoryDB) UpdateEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType,
+	added []list.BlacklistedEntry, removed []string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	typeStore, ok := m.entries[entryType]
+	if !ok {
+		typeStore = make(map[string]map[string]BlacklistResult)
+		m.entries[entryType] = typeStore
+	}
+	listStore, ok := typeStore[l.Name]
+	if !ok {
+		listStore = make(map[string]BlacklistResult)
+		typeStore[l.Name] = listStore
+	}
+	for _, index := range removed {
+		delete(listStore, index)
+	}
+	for _, entry := range added {
+		listStore[entry.Index] = BlacklistResult{
+			Index:     entry.Index,
+			List:      l.Name,
+			ExtraData: entry.ExtraData,
+		}
+	}
+	return nil
+}
+
+//writeEntries writes entries into either the live or the staging store.
+//If ctx is cancelled, the remaining entries are discarded.
+func (m *memoryDB) writeEntries(ctx context.Context,
+	store map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult,
+	entryType list.BlacklistedEntryType, entries <-chan list.BlacklistedEntry,
+	wg *sync.WaitGroup, errorsOut chan<- error) {
+	defer wg.Done()
+	discarded := 0
+	for entry := range entries {
+		if discarded > 0 || ctx.Err() != nil {
+			discarded++
+			continue
+		}
+		err := m.insertEntry(store, entryType, entry)
+		if err != nil {
+			errorsOut <- &WriteError{Entries: 1, Err: err}
+		}
+	}
+	if discarded > 0 {
+		errorsOut <- &WriteError{Entries: discarded, Err: ctx.Err()}
+	}
+}
+
+func (m *memoryDB) insertEntry(
+	store map[list.BlacklistedEntryType]map[string]map[string]BlacklistResult,
+	entryType list.BlacklistedEntryType, entry list.BlacklistedEntry) error {
+	listName := entry.List.GetMetadata().Name
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	typeStore, ok := store[entryType]
+	if !ok {
+		typeStore = make(map[string]map[string]BlacklistResult)
+		store[entryType] = typeStore
+	}
+	listStore, ok := typeStore[listName]
+	if !ok {
+		listStore = make(map[string]BlacklistResult)
+		typeStore[listName] = listStore
+	}
+	//mirror the unique (index, list) constraint of the mongo backend
+	if _, ok := listStore[entry.Index]; ok {
+		return fmt.Errorf("duplicate %s entry %s in list %s", entryType, entry.Index, listName)
+	}
+	listStore[entry.Index] = BlacklistResult{
+		Index:     entry.Index,
+		List:      listName,
+		ExtraData: entry.ExtraData,
+	}
+	return nil
+}
+
+//GetFilters returns the encoded bloom filters stored for a list
+//by entry type
+func (m *memoryDB) GetFilters(ctx context.Context, l list.Metadata) (map[list.BlacklistedEntryType][]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	filters := make(map[list.BlacklistedEntryType][]byte, len(m.filters[l.Name]))
+	for entryType, filter := range m.filters[l.Name] {
+		filters[entryType] = filter
+	}
+	return filters, nil
+}
+
+//SetFilters replaces the encoded bloom filters stored for a list
+func (m *memoryDB) SetFilters(ctx context.Context, l list.Metadata, filters map[list.BlacklistedEntryType][]byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(filters) == 0 {
+		delete(m.filters, l.Name)
+		return nil
+	}
+	//the encoded filters are never modified, so they can be shared
+	stored := make(map[list.BlacklistedEntryType][]byte, len(filters))
+	for entryType, filter := range filters {
+		stored[entryType] = filter
+	}
+	m.filters[l.Name] = stored
+	return nil
+}
+
+//FindEntries finds entries of a given type and index
+func (m *memoryDB) FindEntries(ctx context.Context, dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error) {
+	results, err := m.FindEntriesBatch(ctx, dataType, []string{index})
+	return results[index], err
+}
+
+//FindEntriesBatch finds the entries of a given type for many indexes
+func (m *memoryDB) FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+	indexes []string) (map[string][]BlacklistResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	results := make(map[string][]BlacklistResult)
+	for _, index := range indexes {
+		if _, ok := results[index]; ok {
+			continue
+		}
+		var entries []BlacklistResult
+		for _, listStore := range m.entries[dataType] {
+			if result, ok := listStore[index]; ok {
+				entries = append(entries, result)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		//return the results in a stable order
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].List < entries[j].List
+		})
+		results[index] = entries
+	}
+	return results, nil
+}
+
+//LoadRPCCache retrieves the results stored under the given cache name
+func (m *memoryDB) LoadRPCCache(ctx context.Context, name string) ([]CachedResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	results := make([]CachedResult, 0, len(m.rpcs[name]))
+	for _, result := range m.rpcs[name] {
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+//StoreRPCCache stores results under the given cache name
+func (m *memoryDB) StoreRPCCache(ctx context.Context, name string, results []CachedResult) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cache, ok := m.rpcs[name]
+	if !ok {
+		cache = make(map[string]CachedResult)
+		m.rpcs[name] = cache
+	}
+	for _, result := range results {
+		cache[result.Index] = result
+	}
+	now := time.Now().Unix()
+	for index, result := range cache {
+		if result.Expires <= now {
+			delete(cache, index)
+		}
+	}
+	return nil
+}
+
+//copyMetadata copies a Metadata object so that callers can't modify
+//the data held by the database through shared slices
+func copyMetadata(meta list.Metadata) list.Metadata {
+	metaCopy := meta
+	metaCopy.Types = make([]list.BlacklistedEntryType, len(meta.Types))
+	copy(metaCopy.Types, meta.Types)
+	return metaCopy
+}
@@ -0,0 +1,12 @@
+package database
+
+import "testing"
+
+func TestMemoryDB(t *testing.T) {
+	t.Run("Lists", func(t *testing.T) { testHandleLists(t, NewMemoryDB()) })
+	t.Run("Entries", func(t *testing.T) { testHandleEntries(t, NewMemoryDB()) })
+	t.Run("Staging", func(t *testing.T) { testHandleStaging(t, NewMemoryDB()) })
+	t.Run("UpdateEntries", func(t *testing.T) { testHandleUpdateEntries(t, NewMemoryDB()) })
+	t.Run("Filters", func(t *testing.T) { testHandleFilters(t, NewMemoryDB()) })
+	t.Run("RPCCache", func(t *testing.T) { testHandleRPCCache(t, NewMemoryDB()) })
+}
@@ -1,8 +1,11 @@
 package database
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/activecm/mgosec"
 	"github.com/activecm/rita-bl/list"
@@ -10,15 +13,59 @@ import (
 	"github.com/globalsign/mgo/bson"
 )
 
-//mongoDB provides a MongoDB backend for rita-blacklist
+//mongoDB provides a MongoDB backend for rita-blacklist.
+//Entries are tagged with a generation, and each document in the lists
+//collection holds the generation of entries which is visible for the list.
+//Staged entries are written to the following generation, so committing them
+//only requires bumping the generation held by the list's document.
 type mongoDB struct {
 	session  *mgo.Session
 	database string
 }
 
+//mongoEntry is the document stored in the entry collections
+type mongoEntry struct {
+	BlacklistResult `bson:",inline"`
+	//Generation is the refresh of the list which produced this entry.
+	//Entries inserted before generations were introduced decode as 0.
+	Generation int64
+}
+
+//mongoCachedResult is the document stored in the rpcCacheCollection
+type mongoCachedResult struct {
+	CachedResult `bson:",inline"`
+	//Cache is the name of the cache holding the result
+	Cache string
+}
+
+//mongoFilterChunk is the document stored in the filtersCollection. Filters
+//are split into chunks to stay under mongo's document size limit.
+type mongoFilterChunk struct {
+	//List is the name of the list the filter was built from
+	List string
+	//Type is the entry type the filter was built from
+	Type list.BlacklistedEntryType
+	//Chunk is the position of Data within the filter
+	Chunk int
+	//Chunks is the number of chunks the filter is split into
+	Chunks int
+	//Data holds a part of the encoded filter
+	Data []byte
+}
+
+//mongoFilterChunkSize is the most bytes of a filter held by a single chunk
+const mongoFilterChunkSize = 8 << 20
+
 const listsCollection string = "lists"
 
-//NewMongoDB returns a new mongoDB Handle
+//rpcCacheCollection holds the results stored by StoreRPCCache
+const rpcCacheCollection string = "rpcCache"
+
+//filtersCollection holds the bloom filters stored by SetFilters
+const filtersCollection string = "filters"
+
+//NewMongoDB returns a new mongoDB Handle. The returned Handle implements
+//io.Closer, and it should be closed in order to close its connections.
 func NewMongoDB(conn string, authMech mgosec.AuthMechanism,
 	db string) (Handle, error) {
 	m := new(mongoDB)
@@ -32,7 +79,9 @@ func NewMongoDB(conn string, authMech mgosec.AuthMechanism,
 	return m, nil
 }
 
-//NewSecureMongoDB returns a new mongoDB Handle encrypted with TLS
+//NewSecureMongoDB returns a new mongoDB Handle encrypted with TLS.
+//The returned Handle implements io.Closer like the Handle returned
+//by NewMongoDB.
 func NewSecureMongoDB(conn string, authMech mgosec.AuthMechanism,
 	db string, tlsConf *tls.Config) (Handle, error) {
 	m := new(mongoDB)
@@ -46,19 +95,45 @@ func NewSecureMongoDB(conn string, authMech mgosec.AuthMechanism,
 	return m, nil
 }
 
+//Close closes the connections of the underlying mongo session
+func (m *mongoDB) Close() error {
+	m.session.Close()
+	return nil
+}
+
+//copySession copies the mongoDB session and applies the deadline of ctx
+//to the socket timeout. mgo does not support cancellation, so ctx is
+//otherwise only checked between operations.
+func (m *mongoDB) copySession(ctx context.Context) (*mgo.Session, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	ssn := m.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		ssn.SetSocketTimeout(time.Until(deadline))
+	}
+	return ssn, nil
+}
+
 //GetRegisteredLists retrieves all of the lists registered with the database
-func (m *mongoDB) GetRegisteredLists() ([]list.Metadata, error) {
+func (m *mongoDB) GetRegisteredLists(ctx context.Context) ([]list.Metadata, error) {
 	var lists []list.Metadata
-	ssn := m.session.Copy()
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer ssn.Close()
 
-	err := ssn.DB(m.database).C(listsCollection).Find(nil).All(&lists)
+	err = ssn.DB(m.database).C(listsCollection).Find(nil).All(&lists)
 	return lists, err
 }
 
 //RegisterList registers a new blacklist source with the database
-func (m *mongoDB) RegisterList(l list.Metadata) error {
-	ssn := m.session.Copy()
+func (m *mongoDB) RegisterList(ctx context.Context, l list.Metadata) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer ssn.Close()
 
 	//get the existing collections
@@ -111,27 +186,52 @@ func (m *mongoDB) RegisterList(l list.Metadata) error {
 		//create the collection if it doesn't exist
 		if !found {
 			ssn.DB(m.database).C(string(entryType)).Create(&mgo.CollectionInfo{})
-			ssn.DB(m.database).C(string(entryType)).EnsureIndex(mgo.Index{
-				Key:    []string{"$hashed:index"},
-				Unique: false,
-			})
-			ssn.DB(m.database).C(string(entryType)).EnsureIndex(mgo.Index{
-				Key:    []string{"index", "list"},
-				Unique: true,
-			})
+		}
+		err = ensureEntryIndexes(ssn.DB(m.database).C(string(entryType)))
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+//ensureEntryIndexes creates the indexes used by an entry collection and
+//drops the unique index used before generations were introduced
+func ensureEntryIndexes(coll *mgo.Collection) error {
+	err := coll.EnsureIndex(mgo.Index{
+		Key:    []string{"$hashed:index"},
+		Unique: false,
+	})
+	if err != nil {
+		return err
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:    []string{"index", "list", "generation"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+	//the legacy index may not exist
+	coll.DropIndex("index", "list")
+	return nil
+}
+
 //RemoveList removes an existing blaclist source from the database
-func (m *mongoDB) RemoveList(l list.Metadata) error {
-	err := m.ClearCache(l)
+func (m *mongoDB) RemoveList(ctx context.Context, l list.Metadata) error {
+	err := m.ClearCache(ctx, l)
+	if err != nil {
+		return err
+	}
+	ssn, err := m.copySession(ctx)
 	if err != nil {
 		return err
 	}
-	ssn := m.session.Copy()
 	defer ssn.Close()
+	_, err = ssn.DB(m.database).C(filtersCollection).RemoveAll(bson.M{"list": l.Name})
+	if err != nil {
+		return err
+	}
 	err = ssn.DB(m.database).C(listsCollection).Remove(bson.M{"name": l.Name})
 	if err != nil {
 		return err
@@ -140,18 +240,25 @@ func (m *mongoDB) RemoveList(l list.Metadata) error {
 }
 
 //UpdateListMetadata updates the metadata of an existing blacklist
-func (m *mongoDB) UpdateListMetadata(l list.Metadata) error {
-	ssn := m.session.Copy()
+func (m *mongoDB) UpdateListMetadata(ctx context.Context, l list.Metadata) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer ssn.Close()
-	return ssn.DB(m.database).C(listsCollection).Update(bson.M{"name": l.Name}, l)
+	//$set preserves the generation field which is not held in the Metadata
+	return ssn.DB(m.database).C(listsCollection).Update(bson.M{"name": l.Name}, bson.M{"$set": l})
 }
 
 //ClearCache clears old entries for a given list
-func (m *mongoDB) ClearCache(l list.Metadata) error {
-	ssn := m.session.Copy()
+func (m *mongoDB) ClearCache(ctx context.Context, l list.Metadata) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer ssn.Close()
 	for _, entryType := range l.Types {
-		_, err := ssn.DB(m.database).C(string(entryType)).RemoveAll(bson.M{"list": l.Name})
+		_, err = ssn.DB(m.database).C(string(entryType)).RemoveAll(bson.M{"list": l.Name})
 		if err != nil {
 			return err
 		}
@@ -160,44 +267,589 @@ func (m *mongoDB) ClearCache(l list.Metadata) error {
 }
 
 //InsertEntries inserts entries from a list into the database
-func (m *mongoDB) InsertEntries(entryType list.BlacklistedEntryType,
+func (m *mongoDB) InsertEntries(ctx context.Context, entryType list.BlacklistedEntryType,
 	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
-	ssn := m.session.Copy()
+	m.writeEntries(ctx, entryType, entries, false, wg, errorsOut)
+}
+
+//StageEntries inserts entries from a list into the list's staging area
+func (m *mongoDB) StageEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error) {
+	m.writeEntries(ctx, entryType, entries, true, wg, errorsOut)
+}
+
+//CommitStagedEntries atomically replaces the entries of a list with
+//the entries held in its staging area
+func (m *mongoDB) CommitStagedEntries(ctx context.Context, l list.Metadata) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ssn.Close()
+
+	generation, err := getListGeneration(ssn.DB(m.database), l.Name)
+	if err != nil {
+		return err
+	}
+	//swap in the staged entries
+	err = ssn.DB(m.database).C(listsCollection).Update(
+		bson.M{"name": l.Name},
+		bson.M{"$set": bson.M{"generation": generation + 1}},
+	)
+	if err != nil {
+		return err
+	}
+	//remove the entries which were replaced
+	for _, entryType := range l.Types {
+		_, err = ssn.DB(m.database).C(string(entryType)).RemoveAll(bson.M{
+			"list": l.Name,
+			"$or": []bson.M{
+				{"generation": bson.M{"$lte": generation}},
+				{"generation": bson.M{"$exists": false}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DiscardStagedEntries removes the entries held in a list's staging area
+func (m *mongoDB) DiscardStagedEntries(ctx context.Context, l list.Metadata) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ssn.Close()
+
+	generation, err := getListGeneration(ssn.DB(m.database), l.Name)
+	if err != nil {
+		return err
+	}
+	for _, entryType := range l.Types {
+		_, err = ssn.DB(m.database).C(string(entryType)).RemoveAll(bson.M{
+			"list":       l.Name,
+			"generation": bson.M{"$gt": generation},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//GetListEntries returns the entries of a given type held by a list
+func (m *mongoDB) GetListEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType) ([]BlacklistResult, error) {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ssn.Close()
+
+	generation, err := getListGeneration(ssn.DB(m.database), l.Name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []BlacklistResult
+	var entry mongoEntry
+	iter := ssn.DB(m.database).C(string(entryType)).
+		Find(activeEntriesSelector(l.Name, generation)).
+		Select(bson.M{"index": 1, "list": 1, "extradata": 1}).Iter()
+	for iter.Next(&entry) {
+		entries = append(entries, entry.BlacklistResult)
+		//reset the entry so its ExtraData isn't merged into the next one
+		entry = mongoEntry{}
+		if ctx.Err() != nil {
+			iter.Close()
+			return nil, ctx.Err()
+		}
+	}
+	return entries, iter.Close()
+}
+
+//UpdateEntries inserts the added entries into a list and removes the
+//entries with the removed indexes from the list.
+//Note: the changes are not applied atomically. The added entries are
+//upserted first, which replaces an entry with the same index in a single
+//write, and the other removed entries are removed afterwards. Readers may
+//see some of the changes before the rest, but never miss an entry which
+//the list holds both before and after the update. An update which fails
+//partway may leave entries which should have been removed, and the next
+//incremental refresh removes them.
+func (m *mongoDB) UpdateEntries(ctx context.Context, l list.Metadata, entryType list.BlacklistedEntryType,
+	added []list.BlacklistedEntry, removed []string) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ssn.Close()
+
+	generation, err := getListGeneration(ssn.DB(m.database), l.Name)
+	if err != nil {
+		return err
+	}
+	coll := ssn.DB(m.database).C(string(entryType))
+	buffSize := 100000
+
+	replaced := make(map[string]bool, len(added))
+	for start := 0; start < len(added); start += buffSize {
+		end := start + buffSize
+		if end > len(added) {
+			end = len(added)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		bulk := coll.Bulk()
+		for _, entry := range added[start:end] {
+			replaced[entry.Index] = true
+			selector := activeEntriesSelector(l.Name, generation)
+			selector["index"] = entry.Index
+			bulk.Upsert(selector, mongoEntry{
+				BlacklistResult: BlacklistResult{
+					Index:     entry.Index,
+					List:      l.Name,
+					ExtraData: entry.ExtraData,
+				},
+				Generation: generation,
+			})
+		}
+		_, err = bulk.Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	//entries which were replaced by an upsert must be kept
+	remaining := make([]string, 0, len(removed))
+	for _, index := range removed {
+		if !replaced[index] {
+			remaining = append(remaining, index)
+		}
+	}
+	for start := 0; start < len(remaining); start += buffSize {
+		end := start + buffSize
+		if end > len(remaining) {
+			end = len(remaining)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		selector := activeEntriesSelector(l.Name, generation)
+		selector["index"] = bson.M{"$in": remaining[start:end]}
+		_, err = coll.RemoveAll(selector)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeEntries writes entries to either the active or the staged generation
+//of their lists using unordered bulk inserts, so a duplicate entry doesn't
+//stop the rest of its batch from being written. If ctx is cancelled, the
+//remaining entries are discarded.
+func (m *mongoDB) writeEntries(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, staged bool,
+	wg *sync.WaitGroup, errorsOut chan<- error) {
+	defer wg.Done()
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		//drain the entries so the sender doesn't block
+		discarded := 0
+		for range entries {
+			discarded++
+		}
+		errorsOut <- &WriteError{Entries: discarded, Err: err}
+		return
+	}
 	defer ssn.Close()
 
+	coll := ssn.DB(m.database).C(string(entryType))
+	err = ensureEntryIndexes(coll)
+	if err != nil {
+		errorsOut <- err
+	}
+
+	//cache the generation to write for each list
+	generations := make(map[string]int64)
+
 	i := 0
-	bulk := ssn.DB(m.database).C(string(entryType)).Bulk()
+	bulk := newUnorderedBulk(coll)
 	buffSize := 100000
+	discarded := 0
 	for entry := range entries {
-		bulk.Insert(BlacklistResult{
-			Index:     entry.Index,
-			List:      entry.List.GetMetadata().Name,
-			ExtraData: entry.ExtraData,
+		if discarded > 0 || ctx.Err() != nil {
+			discarded++
+			continue
+		}
+		listName := entry.List.GetMetadata().Name
+		generation, ok := generations[listName]
+		if !ok {
+			generation, err = getListGeneration(ssn.DB(m.database), listName)
+			if err != nil {
+				errorsOut <- err
+			}
+			if staged {
+				generation++
+			}
+			generations[listName] = generation
+		}
+		bulk.Insert(mongoEntry{
+			BlacklistResult: BlacklistResult{
+				Index:     entry.Index,
+				List:      listName,
+				ExtraData: entry.ExtraData,
+			},
+			Generation: generation,
 		})
 		i++
 		if i == buffSize {
-			_, err := bulk.Run()
+			err = runBulkInsert(bulk, i)
 			if err != nil {
 				errorsOut <- err
 			}
 			i = 0
-			bulk = ssn.DB(m.database).C(string(entryType)).Bulk()
+			bulk = newUnorderedBulk(coll)
 		}
 	}
+	if discarded > 0 {
+		//the queued entries are discarded as well
+		errorsOut <- &WriteError{Entries: discarded + i, Err: ctx.Err()}
+		return
+	}
 	if i != 0 {
-		_, err := bulk.Run()
+		err = runBulkInsert(bulk, i)
 		if err != nil {
 			errorsOut <- err
 		}
 	}
-	wg.Done()
+}
+
+//newUnorderedBulk starts an unordered bulk operation on coll
+func newUnorderedBulk(coll *mgo.Collection) *mgo.Bulk {
+	bulk := coll.Bulk()
+	bulk.Unordered()
+	return bulk
+}
+
+//runBulkInsert runs an unordered bulk insert of the given number of entries
+//and returns a WriteError covering the entries which were not inserted
+func runBulkInsert(bulk *mgo.Bulk, entries int) error {
+	_, err := bulk.Run()
+	if err == nil {
+		return nil
+	}
+	failed := entries
+	if bulkErr, ok := err.(*mgo.BulkError); ok {
+		failed = len(bulkErr.Cases())
+		//errors which aren't tied to an entry may have stopped the batch
+		for _, errCase := range bulkErr.Cases() {
+			if errCase.Index < 0 {
+				failed = entries
+				break
+			}
+		}
+	}
+	return &WriteError{Entries: failed, Err: err}
 }
 
 //FindEntries finds entries of a given type and index
-func (m *mongoDB) FindEntries(dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error) {
-	ssn := m.session.Copy()
+func (m *mongoDB) FindEntries(ctx context.Context, dataType list.BlacklistedEntryType, index string) ([]BlacklistResult, error) {
+	results, err := m.FindEntriesBatch(ctx, dataType, []string{index})
+	return results[index], err
+}
+
+//mongoFindBatchSize is the maximum number of indexes sent in a
+//single $in query. This keeps the query documents well under the
+//16MB BSON limit.
+const mongoFindBatchSize = 10000
+
+//FindEntriesBatch finds the entries of a given type for many indexes
+//using $in queries of up to mongoFindBatchSize indexes each. Returns an
+//error if a list is committed during each of mongoFindAttempts reads.
+func (m *mongoDB) FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+	indexes []string) (map[string][]BlacklistResult, error) {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer ssn.Close()
-	var entries []BlacklistResult
-	err := ssn.DB(m.database).C(string(dataType)).Find(bson.M{"index": index}).All(&entries)
-	return entries, err
+
+	//skip repeated indexes so they aren't sent to the server twice
+	uniqueIndexes := make([]string, 0, len(indexes))
+	seen := make(map[string]bool, len(indexes))
+	for _, index := range indexes {
+		if !seen[index] {
+			seen[index] = true
+			uniqueIndexes = append(uniqueIndexes, index)
+		}
+	}
+
+	//CommitStagedEntries removes the replaced generation after bumping
+	//the generation of the list. A commit which happens while the entries
+	//are read may remove the generation which is being read, so the
+	//generations are read again afterwards and the lookup is retried
+	//if any have changed.
+	db := ssn.DB(m.database)
+	generations, err := getListGenerations(db)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < mongoFindAttempts; attempt++ {
+		results, err := findGenerationEntries(ctx, db.C(string(dataType)), uniqueIndexes, generations)
+		if err != nil {
+			return nil, err
+		}
+		current, err := getListGenerations(db)
+		if err != nil {
+			return nil, err
+		}
+		if sameGenerations(generations, current) {
+			return results, nil
+		}
+		generations = current
+	}
+	return nil, errors.New("lists were committed during every attempt to find their entries")
+}
+
+//mongoFindAttempts is the number of times FindEntriesBatch reads the
+//entries before giving up on lists which are being committed
+const mongoFindAttempts = 3
+
+//findGenerationEntries finds the entries of the given indexes which belong
+//to the given generation of their lists
+func findGenerationEntries(ctx context.Context, coll *mgo.Collection, indexes []string,
+	generations map[string]int64) (map[string][]BlacklistResult, error) {
+	results := make(map[string][]BlacklistResult)
+	if len(generations) == 0 {
+		return results, nil
+	}
+	activeEntries := make([]bson.M, 0, len(generations))
+	for listName, generation := range generations {
+		activeEntries = append(activeEntries, activeEntriesSelector(listName, generation))
+	}
+	for start := 0; start < len(indexes); start += mongoFindBatchSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		end := start + mongoFindBatchSize
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+
+		var storedEntries []mongoEntry
+		query := bson.M{
+			"index": bson.M{"$in": indexes[start:end]},
+			"$or":   activeEntries,
+		}
+		err := coll.Find(query).All(&storedEntries)
+		if err != nil {
+			return nil, err
+		}
+		for _, storedEntry := range storedEntries {
+			results[storedEntry.Index] = append(results[storedEntry.Index], storedEntry.BlacklistResult)
+		}
+	}
+	return results, nil
+}
+
+//GetFilters returns the encoded bloom filters stored for a list
+//by entry type. Filters which are missing chunks, e.g. since they are
+//being replaced, are left out.
+func (m *mongoDB) GetFilters(ctx context.Context, l list.Metadata) (map[list.BlacklistedEntryType][]byte, error) {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ssn.Close()
+
+	chunks := make(map[list.BlacklistedEntryType][]mongoFilterChunk)
+	var chunk mongoFilterChunk
+	iter := ssn.DB(m.database).C(filtersCollection).
+		Find(bson.M{"list": l.Name}).Sort("type", "chunk").Iter()
+	for iter.Next(&chunk) {
+		chunks[chunk.Type] = append(chunks[chunk.Type], chunk)
+		chunk = mongoFilterChunk{}
+		if ctx.Err() != nil {
+			iter.Close()
+			return nil, ctx.Err()
+		}
+	}
+	err = iter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make(map[list.BlacklistedEntryType][]byte, len(chunks))
+	for entryType, typeChunks := range chunks {
+		if len(typeChunks) != typeChunks[0].Chunks {
+			continue
+		}
+		var filter []byte
+		for _, typeChunk := range typeChunks {
+			filter = append(filter, typeChunk.Data...)
+		}
+		filters[entryType] = filter
+	}
+	return filters, nil
+}
+
+//SetFilters replaces the encoded bloom filters stored for a list.
+//Note: the filters are not replaced atomically.
+func (m *mongoDB) SetFilters(ctx context.Context, l list.Metadata, filters map[list.BlacklistedEntryType][]byte) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ssn.Close()
+
+	coll := ssn.DB(m.database).C(filtersCollection)
+	err = coll.EnsureIndex(mgo.Index{
+		Key:    []string{"list", "type", "chunk"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = coll.RemoveAll(bson.M{"list": l.Name})
+	if err != nil {
+		return err
+	}
+
+	for entryType, filter := range filters {
+		chunks := (len(filter) + mongoFilterChunkSize - 1) / mongoFilterChunkSize
+		for i := 0; i < chunks; i++ {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			end := (i + 1) * mongoFilterChunkSize
+			if end > len(filter) {
+				end = len(filter)
+			}
+			//chunks are inserted one at a time to keep each write small
+			err = coll.Insert(mongoFilterChunk{
+				List:   l.Name,
+				Type:   entryType,
+				Chunk:  i,
+				Chunks: chunks,
+				Data:   filter[i*mongoFilterChunkSize : end],
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//LoadRPCCache retrieves the results stored under the given cache name
+func (m *mongoDB) LoadRPCCache(ctx context.Context, name string) ([]CachedResult, error) {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ssn.Close()
+
+	var storedResults []mongoCachedResult
+	err = ssn.DB(m.database).C(rpcCacheCollection).Find(bson.M{"cache": name}).All(&storedResults)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]CachedResult, len(storedResults))
+	for i, storedResult := range storedResults {
+		results[i] = storedResult.CachedResult
+	}
+	return results, nil
+}
+
+//StoreRPCCache stores results under the given cache name
+func (m *mongoDB) StoreRPCCache(ctx context.Context, name string, results []CachedResult) error {
+	ssn, err := m.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ssn.Close()
+
+	coll := ssn.DB(m.database).C(rpcCacheCollection)
+	err = coll.EnsureIndex(mgo.Index{
+		Key:    []string{"cache", "index"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(results) > 0 {
+		bulk := coll.Bulk()
+		bulk.Unordered()
+		for _, result := range results {
+			bulk.Upsert(
+				bson.M{"cache": name, "index": result.Index},
+				mongoCachedResult{CachedResult: result, Cache: name},
+			)
+		}
+		_, err = bulk.Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = coll.RemoveAll(bson.M{"cache": name, "expires": bson.M{"$lte": time.Now().Unix()}})
+	return err
+}
+
+//activeEntriesSelector selects the visible entries of a list. Entries
+//inserted before generations were introduced belong to generation 0.
+func activeEntriesSelector(listName string, generation int64) bson.M {
+	if generation == 0 {
+		return bson.M{"list": listName, "generation": bson.M{"$in": []interface{}{0, nil}}}
+	}
+	return bson.M{"list": listName, "generation": generation}
+}
+
+//getListGeneration returns the visible generation of entries for a list
+func getListGeneration(db *mgo.Database, listName string) (int64, error) {
+	var doc struct {
+		Generation int64
+	}
+	err := db.C(listsCollection).Find(bson.M{"name": listName}).
+		Select(bson.M{"generation": 1}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return doc.Generation, err
+}
+
+//getListGenerations returns the visible generation of entries for every list
+func getListGenerations(db *mgo.Database) (map[string]int64, error) {
+	var docs []struct {
+		Name       string
+		Generation int64
+	}
+	err := db.C(listsCollection).Find(nil).
+		Select(bson.M{"name": 1, "generation": 1}).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+	generations := make(map[string]int64, len(docs))
+	for _, doc := range docs {
+		generations[doc.Name] = doc.Generation
+	}
+	return generations, nil
+}
+
+//sameGenerations returns true if both sets of generations hold the same
+//lists with the same generations
+func sameGenerations(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for listName, generation := range a {
+		if other, ok := b[listName]; !ok || other != generation {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,135 @@
+package blacklist
+
+import (
+	"context"
+
+	"github.com/activecm/rita-bl/bloom"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//cachedFilters holds the decoded bloom filters of a list along with the
+//FilterVersion of the list they were loaded for
+type cachedFilters struct {
+	version int64
+	filters map[list.BlacklistedEntryType]*bloom.Filter
+}
+
+//filterSet holds the bloom filters of the registered lists by entry type.
+//Each type holds one filter for each list producing the type. Lists
+//without a filter for the type hold nil, since any index may be present.
+type filterSet map[list.BlacklistedEntryType][]*bloom.Filter
+
+//mayContain returns false if no registered list holds the given index
+func (f filterSet) mayContain(entryType list.BlacklistedEntryType, index string) bool {
+	for _, filter := range f[entryType] {
+		if filter == nil || filter.Test(index) {
+			return true
+		}
+	}
+	return false
+}
+
+//SetBloomFilters enables building a bloom filter of each list's indexes
+//during Update, stored apart from the list's metadata. CheckEntries skips
+//the database lookup for indexes which none of the filters contain.
+//falsePositiveRate sets the chance that an absent index is looked up
+//anyway, e.g. 0.01. Setting it to 0 disables building filters (the default),
+//and lists refreshed without filters lose the filters they held. Lists gain
+//a filter the next time they are fetched. Stored filters are used by
+//CheckEntries regardless of this setting.
+func (b *Blacklist) SetBloomFilters(falsePositiveRate float64) {
+	b.filterRate = falsePositiveRate
+}
+
+//loadFilters returns the bloom filters held by the given lists. Filters are
+//decoded once and reloaded when their list's FilterVersion changes.
+//Returns nil if none of the lists hold filters.
+func (b *Blacklist) loadFilters(ctx context.Context, remoteMetas []list.Metadata,
+	handleError func(error)) filterSet {
+	hasFilters := false
+	for _, remoteMeta := range remoteMetas {
+		if remoteMeta.FilterVersion != 0 {
+			hasFilters = true
+			break
+		}
+	}
+	b.filterMutex.Lock()
+	defer b.filterMutex.Unlock()
+	if !hasFilters {
+		b.filterCache = nil
+		return nil
+	}
+
+	filters := make(filterSet)
+	current := make(map[string]cachedFilters)
+	for _, remoteMeta := range remoteMetas {
+		var cached cachedFilters
+		if remoteMeta.FilterVersion != 0 {
+			var ok bool
+			cached, ok = b.filterCache[remoteMeta.Name]
+			if !ok || cached.version != remoteMeta.FilterVersion {
+				var err error
+				cached, err = b.decodeFilters(ctx, remoteMeta, handleError)
+				if err != nil {
+					//the filters are loaded again by the next check
+					handleError(databaseError(remoteMeta.Name, "", err))
+				}
+			}
+			if cached.filters != nil {
+				current[remoteMeta.Name] = cached
+			}
+		}
+		//lists without a filter for a type may hold any index
+		for _, entryType := range remoteMeta.Types {
+			filters[entryType] = append(filters[entryType], cached.filters[entryType])
+		}
+	}
+	//forget the filters of lists which were removed or lost their filters
+	b.filterCache = current
+	return filters
+}
+
+//decodeFilters reads the bloom filters stored for a list from the database.
+//Filters which can't be decoded are reported and left out.
+func (b *Blacklist) decodeFilters(ctx context.Context, meta list.Metadata,
+	handleError func(error)) (cachedFilters, error) {
+	encodedFilters, err := b.db.GetFilters(ctx, meta)
+	if err != nil {
+		return cachedFilters{}, err
+	}
+	cached := cachedFilters{
+		version: meta.FilterVersion,
+		filters: make(map[list.BlacklistedEntryType]*bloom.Filter, len(encodedFilters)),
+	}
+	for entryType, encoded := range encodedFilters {
+		filter := new(bloom.Filter)
+		err = filter.UnmarshalBinary(encoded)
+		if err != nil {
+			handleError(databaseError(meta.Name, entryType, err))
+			continue
+		}
+		cached.filters[entryType] = filter
+	}
+	return cached, nil
+}
+
+//findEntries looks up the entries of the given indexes, skipping the
+//indexes which the bloom filters rule out. filters may be nil, in which
+//case every index is looked up.
+func (b *Blacklist) findEntries(ctx context.Context, filters filterSet,
+	entryType list.BlacklistedEntryType, indexes []string) (map[string][]database.BlacklistResult, error) {
+	if filters != nil {
+		var candidates []string
+		for _, index := range indexes {
+			if filters.mayContain(entryType, index) {
+				candidates = append(candidates, index)
+			}
+		}
+		if len(candidates) == 0 {
+			return make(map[string][]database.BlacklistResult), nil
+		}
+		indexes = candidates
+	}
+	return b.db.FindEntriesBatch(ctx, entryType, indexes)
+}
@@ -37,6 +37,10 @@ func NewBlacklistedEntry(index string, source List) BlacklistedEntry {
 //entryTypeValidators is a map of entry types to functions which validate them
 var entryTypeValidators map[BlacklistedEntryType]func(string) error
 
+//entryTypeNormalizers is a map of entry types to functions which convert
+//valid indexes into the canonical form used for storage and lookups
+var entryTypeNormalizers map[BlacklistedEntryType]func(string) string
+
 //BlacklistedHostnameType should be added to the metadata types array
 //in order to return hostnames from a list
 const BlacklistedHostnameType BlacklistedEntryType = "hostname"
@@ -71,6 +75,24 @@ func validateHostname(hostname string) error {
 	return nil
 }
 
+//ParentDomains returns the ancestor domains of a hostname in lower case,
+//ordered from the closest parent to the domain directly below the top level
+//domain. Neither the hostname itself nor the top level domain is included,
+//e.g. C2.Evil.com produces evil.com.
+func ParentDomains(hostname string) []string {
+	var parents []string
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for i := strings.IndexByte(hostname, '.'); i != -1; i = strings.IndexByte(hostname, '.') {
+		hostname = hostname[i+1:]
+		//stop before the top level domain
+		if !strings.Contains(hostname, ".") {
+			break
+		}
+		parents = append(parents, hostname)
+	}
+	return parents
+}
+
 //BlacklistedIPType should be added to the metadata types array
 //in order to return ips from a list
 const BlacklistedIPType BlacklistedEntryType = "ip"
@@ -110,9 +132,73 @@ func validateURL(str string) error {
 	return nil
 }
 
+//BlacklistedCIDRType should be added to the metadata types array
+//in order to return CIDR netblocks from a list. IP lookups return the
+//netblocks containing the given address in addition to exact matches.
+const BlacklistedCIDRType BlacklistedEntryType = "cidr"
+
+func validateCIDR(cidr string) error {
+	_, _, err := net.ParseCIDR(cidr)
+	return err
+}
+
+//normalizeCIDR converts a valid CIDR block into its network address
+//form, e.g. 10.1.2.3/8 becomes 10.0.0.0/8
+func normalizeCIDR(cidr string) string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return network.String()
+}
+
+//NormalizeIndex converts an index of a given type into the canonical form
+//used for storage. Indexes which fail validation are returned unchanged.
+func NormalizeIndex(entryType BlacklistedEntryType, index string) string {
+	normalize, ok := entryTypeNormalizers[entryType]
+	if !ok || entryTypeValidators[entryType](index) != nil {
+		return index
+	}
+	return normalize(index)
+}
+
+//KnownEntryType returns true if entries of the given type can be validated
+func KnownEntryType(entryType BlacklistedEntryType) bool {
+	_, ok := entryTypeValidators[entryType]
+	return ok
+}
+
+//ContainingNetworks returns every CIDR block in canonical form which contains
+//the given ip address, ordered from the most specific to the least specific.
+//IPv4 addresses produce 33 networks, and IPv6 addresses produce 129 networks.
+//Looking up these networks as exact indexes keeps containment checks fast
+//regardless of how many netblocks are stored.
+func ContainingNetworks(ip string) ([]string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, errors.New("failed to parse ip address")
+	}
+	bits := 8 * net.IPv6len
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		parsed = ipv4
+		bits = 8 * net.IPv4len
+	}
+	networks := make([]string, 0, bits+1)
+	for ones := bits; ones >= 0; ones-- {
+		mask := net.CIDRMask(ones, bits)
+		network := net.IPNet{IP: parsed.Mask(mask), Mask: mask}
+		networks = append(networks, network.String())
+	}
+	return networks, nil
+}
+
 func init() {
 	entryTypeValidators = make(map[BlacklistedEntryType]func(string) error)
 	entryTypeValidators[BlacklistedHostnameType] = validateHostname
 	entryTypeValidators[BlacklistedIPType] = validateIP
 	entryTypeValidators[BlacklistedURLType] = validateURL
+	entryTypeValidators[BlacklistedCIDRType] = validateCIDR
+
+	entryTypeNormalizers = make(map[BlacklistedEntryType]func(string) string)
+	entryTypeNormalizers[BlacklistedCIDRType] = normalizeCIDR
 }
@@ -96,3 +96,63 @@ func TestValidateURL(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCIDR(t *testing.T) {
+	indexType := string(BlacklistedCIDRType)
+	validator := entryTypeValidators[BlacklistedCIDRType]
+	testCases := []testCase{
+		{"IPv4 network", "10.0.0.0/8", true},
+		{"IPv4 host bits set", "10.1.2.3/8", true},
+		{"IPv4 single host", "192.168.1.1/32", true},
+		{"IPv6 network", "2001:db8::/32", true},
+		{"Missing prefix", "10.0.0.0", false},
+		{"Large prefix", "10.0.0.0/33", false},
+		{"Invalid address", "10.0.0.300/8", false},
+	}
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s: %s", indexType, c.name), func(test *testing.T) {
+			if c.valid {
+				assert.Nil(test, validator(c.index))
+			} else {
+				assert.NotNil(test, validator(c.index))
+			}
+		})
+	}
+}
+
+func TestNormalizeIndex(t *testing.T) {
+	assert.Equal(t, "10.0.0.0/8", NormalizeIndex(BlacklistedCIDRType, "10.1.2.3/8"))
+	assert.Equal(t, "2001:db8::/32", NormalizeIndex(BlacklistedCIDRType, "2001:db8:1::/32"))
+	assert.Equal(t, "not a cidr", NormalizeIndex(BlacklistedCIDRType, "not a cidr"))
+	assert.Equal(t, "10.1.2.3", NormalizeIndex(BlacklistedIPType, "10.1.2.3"))
+}
+
+func TestContainingNetworks(t *testing.T) {
+	networks, err := ContainingNetworks("10.1.2.3")
+	assert.Nil(t, err)
+	assert.Len(t, networks, 33)
+	assert.Equal(t, "10.1.2.3/32", networks[0])
+	assert.Contains(t, networks, "10.1.2.0/24")
+	assert.Contains(t, networks, "10.0.0.0/8")
+	assert.Equal(t, "0.0.0.0/0", networks[32])
+
+	networks, err = ContainingNetworks("2001:db8::1")
+	assert.Nil(t, err)
+	assert.Len(t, networks, 129)
+	assert.Contains(t, networks, "2001:db8::/32")
+
+	_, err = ContainingNetworks("10.1.2")
+	assert.NotNil(t, err)
+}
+
+func TestParentDomains(t *testing.T) {
+	assert.Equal(t, []string{"evil.com"}, ParentDomains("c2.evil.com"))
+	assert.Equal(t, []string{"evil.com"}, ParentDomains("c2.evil.com."))
+	assert.Equal(t, []string{"b.evil.co.uk", "evil.co.uk", "co.uk"}, ParentDomains("a.b.evil.co.uk"))
+	assert.Len(t, ParentDomains("evil.com"), 0)
+	assert.Len(t, ParentDomains("localhost"), 0)
+
+	//parent domains are lower case
+	assert.Equal(t, []string{"evil.com"}, ParentDomains("C2.Evil.COM"))
+	assert.Equal(t, []string{"b.evil.com", "evil.com"}, ParentDomains("a.B.eViL.com."))
+}
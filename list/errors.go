@@ -0,0 +1,88 @@
+package list
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ErrorCategory describes which stage of rita-blacklist produced an error
+type ErrorCategory string
+
+const (
+	//CategoryFetch is used for errors which occur while retrieving a list
+	CategoryFetch ErrorCategory = "fetch"
+	//CategoryParse is used for errors which occur while reading the
+	//data retrieved for a list
+	CategoryParse ErrorCategory = "parse"
+	//CategoryRecord is used for individual records of a list which can't
+	//be read and are skipped. Unlike the other errors sent by FetchData,
+	//these errors don't fail the fetch.
+	CategoryRecord ErrorCategory = "record"
+	//CategoryValidate is used for entries which fail validation
+	CategoryValidate ErrorCategory = "validate"
+	//CategoryDatabase is used for errors returned by the database
+	CategoryDatabase ErrorCategory = "database"
+	//CategoryRPC is used for errors returned by remote procedure calls
+	CategoryRPC ErrorCategory = "rpc"
+)
+
+//BlacklistError is the error type reported to the errorHandler. It records
+//where an error came from so callers can tell, for example, a bad line in
+//a list apart from a database outage. Use errors.As to retrieve it.
+type BlacklistError struct {
+	//Category is the stage which produced the error
+	Category ErrorCategory
+	//List is the name of the list the error relates to, if any
+	List string
+	//EntryType is the type of entry the error relates to, if any
+	EntryType BlacklistedEntryType
+	//Index is the raw index which caused the error, if any
+	Index string
+	//Err is the underlying error
+	Err error
+}
+
+//Error implements the error interface
+func (e *BlacklistError) Error() string {
+	var context []string
+	if e.List != "" {
+		context = append(context, "list "+e.List)
+	}
+	if e.EntryType != "" {
+		context = append(context, "type "+string(e.EntryType))
+	}
+	if e.Index != "" {
+		context = append(context, fmt.Sprintf("index %q", e.Index))
+	}
+	if len(context) == 0 {
+		return fmt.Sprintf("%s error: %v", e.Category, e.Err)
+	}
+	return fmt.Sprintf("%s error (%s): %v", e.Category, strings.Join(context, ", "), e.Err)
+}
+
+//Unwrap returns the underlying error
+func (e *BlacklistError) Unwrap() error {
+	return e.Err
+}
+
+//WrapError wraps err in a BlacklistError. Errors which already contain a
+//BlacklistError are returned unchanged so the original context is kept.
+//Returns nil if err is nil.
+func WrapError(category ErrorCategory, listName string, entryType BlacklistedEntryType,
+	index string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var blErr *BlacklistError
+	if errors.As(err, &blErr) {
+		return err
+	}
+	return &BlacklistError{
+		Category:  category,
+		List:      listName,
+		EntryType: entryType,
+		Index:     index,
+		Err:       err,
+	}
+}
@@ -0,0 +1,32 @@
+package list
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWrapError(t *testing.T) {
+	if WrapError(CategoryFetch, "test", "", "", nil) != nil {
+		t.Error("wrapping a nil error should return nil")
+	}
+
+	baseErr := errors.New("failed to parse ip address")
+	err := WrapError(CategoryValidate, "test", BlacklistedIPType, "1.2.3", baseErr)
+	var blErr *BlacklistError
+	if !errors.As(err, &blErr) || !errors.Is(err, baseErr) {
+		t.FailNow()
+	}
+	if blErr.Category != CategoryValidate || blErr.List != "test" ||
+		blErr.EntryType != BlacklistedIPType || blErr.Index != "1.2.3" {
+		t.Errorf("unexpected error fields: %+v", blErr)
+	}
+	expected := `validate error (list test, type ip, index "1.2.3"): failed to parse ip address`
+	if err.Error() != expected {
+		t.Errorf("expected %s, got %s", expected, err.Error())
+	}
+
+	//the original context is kept when an error is wrapped again
+	if WrapError(CategoryDatabase, "other", "", "", err) != err {
+		t.Error("wrapping a BlacklistError should return it unchanged")
+	}
+}
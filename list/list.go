@@ -1,9 +1,16 @@
 package list
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
+//ErrNotModified may be sent by FetchData instead of any entries to signal
+//that the list has not changed since it was last fetched. The entries held
+//by the database are kept and only the list's metadata is updated.
+var ErrNotModified = errors.New("list not modified since the last fetch")
+
 type (
 	//List provides an interface for fetching a list of blacklisted items
 	List interface {
@@ -14,8 +21,13 @@ type (
 		//FetchData fetches the BlacklistedEntrys associated with this blacklist.
 		//This function must close the channels supplied in the entryMap.
 		//This function should not close errorsOut as it is part of a larger
-		//pipeline.
-		FetchData(entryMap BlacklistedEntryMap, errorsOut chan<- error)
+		//pipeline. This function should stop fetching and report ctx.Err()
+		//if ctx is cancelled. This function may send ErrNotModified if the
+		//list is unchanged since the fetch recorded in its Metadata.
+		//Any other error fails the fetch, except for errors wrapped in a
+		//BlacklistError with CategoryRecord, which should be used to report
+		//records which are skipped.
+		FetchData(ctx context.Context, entryMap BlacklistedEntryMap, errorsOut chan<- error)
 	}
 
 	//Metadata stores the name of the blacklist source as well as other
@@ -30,6 +42,23 @@ type (
 		LastUpdate int64
 		//CacheTime is the time in seconds the data from this list should be cached
 		CacheTime int64
+		//SuffixMatch causes the hostname entries of this list to match their
+		//subdomains as well, e.g. an entry for evil.com matches c2.evil.com
+		SuffixMatch bool
+		//FilterVersion changes whenever the bloom filters of the list's
+		//indexes are replaced. Lists without filters hold 0. Filters are
+		//built during Update when enabled with SetBloomFilters and are
+		//stored apart from the Metadata.
+		FilterVersion int64
+		//ETag is the entity tag returned by the server the last time the
+		//list was downloaded, if any
+		ETag string
+		//LastModified is the Last-Modified header returned by the server
+		//the last time the list was downloaded, if any
+		LastModified string
+		//ContentHash is the hex encoded SHA-256 hash of the data
+		//downloaded the last time the list was fetched, if any
+		ContentHash string
 	}
 
 	//BlacklistedEntryMap is a map of BlacklistedEntryTypes to go channels.
@@ -56,18 +85,43 @@ func ShouldFetch(m Metadata) bool {
 //validates the entries coming from the list, and returns a channel
 //consisting of the validated entries. errorHandler is used to handle any
 //errors that arrise in the processing of the entries
-func FetchAndValidateEntries(l List, errorsOut chan<- error) BlacklistedEntryMap {
+func FetchAndValidateEntries(ctx context.Context, l List, errorsOut chan<- error) BlacklistedEntryMap {
 	//fetch the data
 	rawOutput := NewBlacklistedEntryMap(l.GetMetadata().Types...)
-	go l.FetchData(rawOutput, errorsOut)
+	go l.FetchData(ctx, rawOutput, errorsOut)
 
 	//validate the data
-	validatedOutput := NewBlacklistedEntryMap(l.GetMetadata().Types...)
-	go validateHelper(rawOutput, validatedOutput, errorsOut)
+	return ValidateEntries(ctx, rawOutput, errorsOut)
+}
+
+//ValidateEntries validates the entries coming from a BlacklistedEntryMap
+//and returns a new BlacklistedEntryMap consisting of the validated entries.
+//The returned channels are closed once the input channels are closed.
+//Entries which fail validation are reported to errorsOut. If ctx is
+//cancelled, the remaining input entries are discarded.
+func ValidateEntries(ctx context.Context, entryMap BlacklistedEntryMap, errorsOut chan<- error) BlacklistedEntryMap {
+	types := make([]BlacklistedEntryType, 0, len(entryMap))
+	for entryType := range entryMap {
+		types = append(types, entryType)
+	}
+	validatedOutput := NewBlacklistedEntryMap(types...)
+	go validateHelper(ctx, entryMap, validatedOutput, errorsOut)
 	return validatedOutput
 }
 
-func validateHelper(inputEntryMap BlacklistedEntryMap,
+//SendEntry sends an entry on the given channel unless ctx is cancelled
+//first. Returns false if ctx was cancelled. Lists should use this function
+//to send entries from FetchData.
+func SendEntry(ctx context.Context, entries chan<- BlacklistedEntry, entry BlacklistedEntry) bool {
+	select {
+	case entries <- entry:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func validateHelper(ctx context.Context, inputEntryMap BlacklistedEntryMap,
 	outputEntryMap BlacklistedEntryMap, errorsOut chan<- error) {
 	for inputEntryType, inputEntryChannel := range inputEntryMap {
 
@@ -78,11 +132,19 @@ func validateHelper(inputEntryMap BlacklistedEntryMap,
 			errorsChannel chan<- error) {
 
 			for entry := range inputChannel {
+				//drain the input so lists which ignore ctx don't block
+				if ctx.Err() != nil {
+					continue
+				}
 				err := entryTypeValidators[entryType](entry.Index)
 				if err == nil {
-					outputChannel <- entry
+					if normalize, ok := entryTypeNormalizers[entryType]; ok {
+						entry.Index = normalize(entry.Index)
+					}
+					SendEntry(ctx, outputChannel, entry)
 				} else {
-					errorsChannel <- err
+					errorsChannel <- WrapError(CategoryValidate,
+						entryListName(entry), entryType, entry.Index, err)
 				}
 			}
 			close(outputChannel)
@@ -91,3 +153,11 @@ func validateHelper(inputEntryMap BlacklistedEntryMap,
 	}
 
 }
+
+//entryListName returns the name of the list an entry came from
+func entryListName(entry BlacklistedEntry) string {
+	if entry.List == nil {
+		return ""
+	}
+	return entry.List.GetMetadata().Name
+}
@@ -0,0 +1,66 @@
+package blacklist
+
+import (
+	"context"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+//cachedMetadata holds the metadata of the registered lists read by a check
+type cachedMetadata struct {
+	metas   []list.Metadata
+	expires time.Time
+}
+
+//SetMetadataCacheTime sets how long checks reuse the metadata of the
+//registered lists before reading it from the database again. Update
+//discards the cached metadata, so this only delays noticing lists updated
+//by other processes. Setting it to 0 reads the metadata on every check.
+//Defaults to 10 seconds.
+func (b *Blacklist) SetMetadataCacheTime(cacheTime time.Duration) {
+	b.metaMutex.Lock()
+	defer b.metaMutex.Unlock()
+	b.metaCacheTime = cacheTime
+	b.metaCache = nil
+}
+
+//registeredLists returns the metadata of the registered lists, reusing the
+//metadata read by a recent check. The metadata is read from the database
+//while an Update is running, since the Update changes it.
+func (b *Blacklist) registeredLists(ctx context.Context) ([]list.Metadata, error) {
+	b.metaMutex.Lock()
+	if b.metaCache != nil && time.Now().Before(b.metaCache.expires) {
+		metas := b.metaCache.metas
+		b.metaMutex.Unlock()
+		return metas, nil
+	}
+	generation := b.metaGeneration
+	b.metaMutex.Unlock()
+
+	metas, err := b.db.GetRegisteredLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//metadata read while an Update was running may already be stale
+	b.metaMutex.Lock()
+	defer b.metaMutex.Unlock()
+	if b.updating == 0 && generation == b.metaGeneration && b.metaCacheTime > 0 {
+		b.metaCache = &cachedMetadata{
+			metas:   metas,
+			expires: time.Now().Add(b.metaCacheTime),
+		}
+	}
+	return metas, nil
+}
+
+//invalidateMetadata discards the cached metadata when an Update starts
+//or finishes. updating is 1 when an Update starts and -1 when it finishes.
+func (b *Blacklist) invalidateMetadata(updating int) {
+	b.metaMutex.Lock()
+	defer b.metaMutex.Unlock()
+	b.updating += updating
+	b.metaGeneration++
+	b.metaCache = nil
+}
@@ -0,0 +1,294 @@
+//Package server exposes a Blacklist over HTTP so that tools can check
+//indexes without embedding the library or connecting to the database.
+//
+//The endpoints are:
+//
+//	GET  /check/{type}?index=X[&suffix=true]  check a single index
+//	POST /check/{type}                        check a batch of indexes
+//	GET  /lists                               show the registered lists
+//	POST /update                              update the lists
+//
+//Every endpoint responds with JSON. Errors are sent as {"error": "..."}.
+//Lookups which fail because the database or an RPC is unavailable are
+//answered with 503 Service Unavailable rather than empty results.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//DefaultMaxBatchSize is the number of indexes a bulk lookup may hold
+//unless changed with SetMaxBatchSize
+const DefaultMaxBatchSize = 10000
+
+//maxBytesPerIndex bounds the size of bulk lookup bodies.
+//Indexes longer than this are rare, e.g. very long URLs.
+const maxBytesPerIndex = 4096
+
+type (
+	//Server serves lookups against a Blacklist over HTTP
+	Server struct {
+		blacklist    *blacklist.Blacklist
+		mux          *http.ServeMux
+		maxBatchSize int
+		updateMutex  *sync.Mutex
+		updating     bool
+		//ctx is cancelled by Close. Updates run on ctx rather than the
+		//request's context so clients can't interrupt them.
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	//CheckResponse is sent by single lookups
+	CheckResponse struct {
+		//Index is the index which was checked
+		Index string `json:"index"`
+		//Results holds the entries which matched the index
+		Results []database.BlacklistResult `json:"results"`
+	}
+
+	//BulkCheckRequest is the body of bulk lookups
+	BulkCheckRequest struct {
+		//Indexes holds the indexes to check
+		Indexes []string `json:"indexes"`
+		//SuffixMatch causes hostnames to match the entries of
+		//their parent domains
+		SuffixMatch bool `json:"suffixMatch"`
+	}
+
+	//BulkCheckResponse is sent by bulk lookups
+	BulkCheckResponse struct {
+		//Results maps each index which was checked to the entries
+		//which matched it. Indexes without matches map to an empty array.
+		Results map[string][]database.BlacklistResult `json:"results"`
+	}
+
+	//ListStatus describes a list registered with the database
+	ListStatus struct {
+		Name        string   `json:"name"`
+		Types       []string `json:"types"`
+		LastUpdate  int64    `json:"lastUpdate"`
+		CacheTime   int64    `json:"cacheTime"`
+		SuffixMatch bool     `json:"suffixMatch"`
+		//Stale is true if the list will be fetched by the next update
+		Stale bool `json:"stale"`
+	}
+
+	//errorResponse is sent when a request fails
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+//NewServer creates a Server for the given Blacklist. The lists updated
+//by the update endpoint are the ones loaded with Blacklist.SetLists.
+func NewServer(b *blacklist.Blacklist) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		blacklist:    b,
+		mux:          http.NewServeMux(),
+		maxBatchSize: DefaultMaxBatchSize,
+		updateMutex:  new(sync.Mutex),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	s.mux.HandleFunc("/check/", s.handleCheck)
+	s.mux.HandleFunc("/lists", s.handleLists)
+	s.mux.HandleFunc("/update", s.handleUpdate)
+	return s
+}
+
+//SetMaxBatchSize sets the number of indexes a bulk lookup may hold.
+//Defaults to DefaultMaxBatchSize.
+func (s *Server) SetMaxBatchSize(size int) {
+	s.maxBatchSize = size
+}
+
+//Close cancels any update which is running. Updates which are requested
+//after the Server is closed fail immediately.
+func (s *Server) Close() {
+	s.cancel()
+}
+
+//ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//handleCheck serves single lookups with GET and bulk lookups with POST
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	entryType := list.BlacklistedEntryType(strings.TrimPrefix(r.URL.Path, "/check/"))
+	if !list.KnownEntryType(entryType) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown entry type %q", entryType))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.checkSingle(w, r, entryType)
+	case http.MethodPost:
+		s.checkBulk(w, r, entryType)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+//checkSingle checks the index given in the query string
+func (s *Server) checkSingle(w http.ResponseWriter, r *http.Request, entryType list.BlacklistedEntryType) {
+	query := r.URL.Query()
+	index := query.Get("index")
+	if index == "" {
+		writeError(w, http.StatusBadRequest, errors.New("index must be set"))
+		return
+	}
+	var opts blacklist.CheckOptions
+	if suffix := query.Get("suffix"); suffix != "" {
+		var err error
+		opts.SuffixMatch, err = strconv.ParseBool(suffix)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid suffix %q", suffix))
+			return
+		}
+	}
+
+	results, errs := s.blacklist.CheckEntriesWithErrors(r.Context(), opts, entryType, index)
+	if err := lookupFailure(errs); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CheckResponse{Index: index, Results: nonNil(results[index])})
+}
+
+//checkBulk checks the indexes held by a BulkCheckRequest
+func (s *Server) checkBulk(w http.ResponseWriter, r *http.Request, entryType list.BlacklistedEntryType) {
+	var req BulkCheckRequest
+	body := http.MaxBytesReader(w, r.Body, int64(s.maxBatchSize+1)*maxBytesPerIndex)
+	err := json.NewDecoder(body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.Indexes) > s.maxBatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("at most %d indexes may be checked at once", s.maxBatchSize))
+		return
+	}
+
+	results, errs := s.blacklist.CheckEntriesWithErrors(r.Context(),
+		blacklist.CheckOptions{SuffixMatch: req.SuffixMatch}, entryType, req.Indexes...)
+	if err := lookupFailure(errs); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	resp := BulkCheckResponse{Results: make(map[string][]database.BlacklistResult, len(req.Indexes))}
+	for _, index := range req.Indexes {
+		resp.Results[index] = nonNil(results[index])
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+//handleLists sends the status of the registered lists
+func (s *Server) handleLists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	metas, err := s.blacklist.GetRegisteredLists(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statuses := make([]ListStatus, len(metas))
+	for i, meta := range metas {
+		types := make([]string, len(meta.Types))
+		for j, entryType := range meta.Types {
+			types[j] = string(entryType)
+		}
+		statuses[i] = ListStatus{
+			Name:        meta.Name,
+			Types:       types,
+			LastUpdate:  meta.LastUpdate,
+			CacheTime:   meta.CacheTime,
+			SuffixMatch: meta.SuffixMatch,
+			Stale:       list.ShouldFetch(meta),
+		}
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+//handleUpdate runs an update and sends its reports. Only one update runs
+//at a time. Requests made while an update is running are rejected.
+//The update keeps running if the client disconnects.
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	s.updateMutex.Lock()
+	if s.updating {
+		s.updateMutex.Unlock()
+		writeError(w, http.StatusConflict, errors.New("an update is already running"))
+		return
+	}
+	s.updating = true
+	s.updateMutex.Unlock()
+	defer func() {
+		s.updateMutex.Lock()
+		s.updating = false
+		s.updateMutex.Unlock()
+	}()
+
+	reports := s.blacklist.UpdateContext(s.ctx)
+	writeJSON(w, http.StatusOK, reports)
+}
+
+//lookupFailure returns the first of the errors from a lookup which means
+//the results may be incomplete, such as a database or RPC error. Invalid
+//indexes don't cause a failure, since they are simply not blacklisted.
+func lookupFailure(errs []error) error {
+	for _, err := range errs {
+		var blErr *list.BlacklistError
+		if errors.As(err, &blErr) && blErr.Category == list.CategoryValidate {
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+//writeJSON sends value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+//writeError sends err as the body of a response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+//nonNil returns an empty slice in place of nil so that indexes without
+//matches are encoded as an empty array rather than null
+func nonNil(results []database.BlacklistResult) []database.BlacklistResult {
+	if results == nil {
+		return []database.BlacklistResult{}
+	}
+	return results
+}
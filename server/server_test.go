@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+	ips := lists.NewLineSeparatedList(list.BlacklistedIPType, "ips", 86400, func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("10.10.10.10\n10.10.10.11\n")), nil
+	})
+	hosts := lists.NewLineSeparatedList(list.BlacklistedHostnameType, "hosts", 86400, func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("example.com\n")), nil
+	})
+	b.SetLists(ips, hosts)
+	server := httptest.NewServer(NewServer(b))
+	t.Cleanup(server.Close)
+	return server
+}
+
+//request makes a request and decodes the JSON response into out
+func request(t *testing.T, method, url string, body interface{}, out interface{}) int {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("%s %s: unexpected content type %s", method, url, resp.Header.Get("Content-Type"))
+	}
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	server := newTestServer(t)
+
+	//nothing is registered before the first update
+	var statuses []ListStatus
+	if request(t, http.MethodGet, server.URL+"/lists", nil, &statuses) != http.StatusOK || len(statuses) != 0 {
+		t.Fatalf("unexpected lists before update: %+v", statuses)
+	}
+
+	var reports []struct {
+		Action string
+		Added  int
+	}
+	if request(t, http.MethodPost, server.URL+"/update", nil, &reports) != http.StatusOK {
+		t.Fatal("update failed")
+	}
+	if len(reports) != 2 || reports[0].Action != string(blacklist.ActionCreated) || reports[0].Added != 2 {
+		t.Errorf("unexpected update reports: %+v", reports)
+	}
+
+	statuses = nil
+	request(t, http.MethodGet, server.URL+"/lists", nil, &statuses)
+	if len(statuses) != 2 || statuses[0].LastUpdate == 0 || statuses[0].Stale {
+		t.Errorf("unexpected lists after update: %+v", statuses)
+	}
+
+	var single CheckResponse
+	status := request(t, http.MethodGet, server.URL+"/check/ip?index=10.10.10.10", nil, &single)
+	if status != http.StatusOK || len(single.Results) != 1 || single.Results[0].List != "ips" {
+		t.Errorf("unexpected single lookup %d: %+v", status, single)
+	}
+
+	single = CheckResponse{}
+	query := url.Values{"index": {"www.example.com"}, "suffix": {"true"}}
+	request(t, http.MethodGet, server.URL+"/check/hostname?"+query.Encode(), nil, &single)
+	if len(single.Results) != 1 || single.Results[0].Index != "example.com" {
+		t.Errorf("unexpected suffix lookup: %+v", single)
+	}
+
+	var bulk BulkCheckResponse
+	status = request(t, http.MethodPost, server.URL+"/check/ip",
+		BulkCheckRequest{Indexes: []string{"10.10.10.11", "127.0.0.1"}}, &bulk)
+	if status != http.StatusOK || len(bulk.Results) != 2 ||
+		len(bulk.Results["10.10.10.11"]) != 1 || bulk.Results["127.0.0.1"] == nil {
+		t.Errorf("unexpected bulk lookup %d: %+v", status, bulk)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server := newTestServer(t)
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{http.MethodGet, "/check/email?index=a", nil, http.StatusNotFound},
+		{http.MethodGet, "/check/ip", nil, http.StatusBadRequest},
+		{http.MethodGet, "/check/hostname?index=a.com&suffix=maybe", nil, http.StatusBadRequest},
+		{http.MethodPost, "/check/ip", "not an object", http.StatusBadRequest},
+		{http.MethodDelete, "/check/ip", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/lists", nil, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/update", nil, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		var resp errorResponse
+		status := request(t, c.method, server.URL+c.path, c.body, &resp)
+		if status != c.status || resp.Error == "" {
+			t.Errorf("%s %s: expected %d with an error, got %d %+v", c.method, c.path, c.status, status, resp)
+		}
+	}
+}
+
+func TestServerMaxBatchSize(t *testing.T) {
+	b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+	s := NewServer(b)
+	s.SetMaxBatchSize(2)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var resp errorResponse
+	status := request(t, http.MethodPost, server.URL+"/check/ip",
+		BulkCheckRequest{Indexes: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}}, &resp)
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d, got %d %+v", http.StatusRequestEntityTooLarge, status, resp)
+	}
+}
+
+//failingDB fails every lookup
+type failingDB struct {
+	database.Handle
+}
+
+func (failingDB) FindEntriesBatch(ctx context.Context, dataType list.BlacklistedEntryType,
+	indexes []string) (map[string][]database.BlacklistResult, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func TestServerLookupFailure(t *testing.T) {
+	b := blacklist.NewBlacklist(failingDB{database.NewMemoryDB()}, func(err error) { t.Error(err) })
+	server := httptest.NewServer(NewServer(b))
+	defer server.Close()
+
+	//a failed lookup must not look like an index which isn't blacklisted
+	var resp errorResponse
+	status := request(t, http.MethodGet, server.URL+"/check/url?index=http://evil.com", nil, &resp)
+	if status != http.StatusServiceUnavailable || resp.Error == "" {
+		t.Errorf("unexpected single lookup %d: %+v", status, resp)
+	}
+	resp = errorResponse{}
+	status = request(t, http.MethodPost, server.URL+"/check/url",
+		BulkCheckRequest{Indexes: []string{"http://evil.com"}}, &resp)
+	if status != http.StatusServiceUnavailable || resp.Error == "" {
+		t.Errorf("unexpected bulk lookup %d: %+v", status, resp)
+	}
+}
+
+func TestServerUpdateOutlivesRequest(t *testing.T) {
+	db := database.NewMemoryDB()
+	b := blacklist.NewBlacklist(db, func(err error) { t.Error(err) })
+	started := make(chan struct{})
+	source := func(ctx context.Context, _ *list.Metadata) (io.ReadCloser, error) {
+		close(started)
+		//give the server time to notice the client went away
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+		return io.NopCloser(strings.NewReader("10.10.10.10\n")), nil
+	}
+	b.SetLists(lists.NewLineSeparatedListFromSource(list.BlacklistedIPType, "slow", 86400, source))
+	s := NewServer(b)
+	defer s.Close()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	//the client gives up while the list is being fetched
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/update", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		metas, err := db.GetRegisteredLists(context.Background())
+		if err == nil && len(metas) == 1 && metas[0].LastUpdate != 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the update was interrupted by the client")
+}
@@ -0,0 +1,582 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists"
+	"github.com/activecm/rita-bl/sources/lists/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Syntax is the syntax a config file is written in
+type Syntax string
+
+const (
+	//SyntaxYAML is used for YAML config files
+	SyntaxYAML Syntax = "yaml"
+	//SyntaxJSON is used for JSON config files
+	SyntaxJSON Syntax = "json"
+)
+
+type (
+	//Config declares a set of lists and how they are downloaded
+	Config struct {
+		//HTTP configures the requests made by lists with a URL
+		HTTP HTTPConfig `yaml:"http" json:"http"`
+		//Lists declares the lists to load
+		Lists []ListConfig `yaml:"lists" json:"lists"`
+	}
+
+	//HTTPConfig configures the requests made by lists with a URL
+	HTTPConfig struct {
+		//Proxy is the URL of the proxy requests are sent through
+		Proxy string `yaml:"proxy" json:"proxy"`
+		//Timeout limits the time spent on a request, e.g. "30s"
+		Timeout string `yaml:"timeout" json:"timeout"`
+		//UserAgent is sent with every request
+		UserAgent string `yaml:"userAgent" json:"userAgent"`
+		//Headers are added to every request
+		Headers map[string]string `yaml:"headers" json:"headers"`
+		//CAFile is a PEM file of certificate authorities to trust in
+		//addition to the system's. Relative paths are resolved against
+		//the directory holding the config file.
+		CAFile string `yaml:"caFile" json:"caFile"`
+	}
+
+	//ListConfig declares a single list
+	ListConfig struct {
+		//Name is the unique name of the list
+		Name string `yaml:"name" json:"name"`
+		//URL is the location the list is downloaded from. Either URL or
+		//File must be set.
+		URL string `yaml:"url" json:"url"`
+		//File is the path the list is read from. Relative paths are
+		//resolved against the directory holding the config file.
+		File string `yaml:"file" json:"file"`
+		//Format is the format of the list's data. Defaults to "lines".
+		Format string `yaml:"format" json:"format"`
+		//Type is the BlacklistedEntryType of the list's entries. It must
+		//not be set for the stix, taxii, and misp formats, whose entries
+		//take their types from the data, or for the hosts and adblock
+		//formats, whose entries are always hostnames.
+		Type string `yaml:"type" json:"type"`
+		//CacheTime is the time in seconds the list's data is cached
+		CacheTime int64 `yaml:"cacheTime" json:"cacheTime"`
+		//Compression is the compression applied to the list's data.
+		//One of "none" (the default), "gzip", or "zip".
+		Compression string `yaml:"compression" json:"compression"`
+		//SuffixMatch causes hostname entries to match their subdomains
+		SuffixMatch bool `yaml:"suffixMatch" json:"suffixMatch"`
+		//Options holds settings specific to the list's format
+		Options map[string]string `yaml:"options" json:"options"`
+	}
+)
+
+//FieldError describes an invalid setting in a Config
+type FieldError struct {
+	//Field is the path to the setting, e.g. lists[2].url
+	Field string
+	//List is the name of the list holding the setting, if any
+	List string
+	//Message describes the problem
+	Message string
+}
+
+//Error implements the error interface
+func (e *FieldError) Error() string {
+	if e.List != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Field, e.List, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+//ValidationError holds every problem found while validating a Config
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+//Error implements the error interface
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(messages, "; "))
+}
+
+//formatBuilder creates lists of a given format
+type formatBuilder struct {
+	//options holds the names of the Options the format accepts
+	options []string
+	//untyped is true if the format determines the types of its entries
+	//itself, so lists must not set a Type
+	untyped bool
+	//remote is true if the format downloads its data itself, so lists
+	//must set a URL and must not set a Compression
+	remote bool
+	//streaming is true if the format decodes its data as it is read, so
+	//the data is streamed rather than read into memory to hash it
+	streaming bool
+	//build creates a list from its config and data source. The source is
+	//nil for remote formats, which use the fetcher instead.
+	build func(lc ListConfig, source lists.DataSource, fetcher *util.Fetcher) (list.List, error)
+}
+
+//formats maps the names accepted in ListConfig.Format to their builders
+var formats = map[string]formatBuilder{
+	"lines": {
+		build: func(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+			return lists.NewLineSeparatedListFromSource(
+				list.BlacklistedEntryType(lc.Type), lc.Name, lc.CacheTime, source,
+			), nil
+		},
+	},
+	"csv": {
+		options: []string{"index", "columns", "header", "delimiter", "comment",
+			"lazyQuotes", "trimSpace", "timeLayout"},
+		build: buildCSVList,
+	},
+	"json": {
+		options:   []string{"index", "indexes", "fields", "items"},
+		streaming: true,
+		build:     buildJSONList,
+	},
+	"stix": {
+		untyped: true,
+		build: func(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+			return lists.NewSTIXListFromSource(lc.Name, lc.CacheTime, source), nil
+		},
+	},
+	"hosts": {
+		untyped: true,
+		build: func(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+			return lists.NewHostsListFromSource(lc.Name, lc.CacheTime, source), nil
+		},
+	},
+	"adblock": {
+		untyped: true,
+		build: func(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+			return lists.NewAdblockListFromSource(lc.Name, lc.CacheTime, source), nil
+		},
+	},
+	"misp": {
+		untyped: true,
+		build: func(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+			return lists.NewMISPListFromSource(lc.Name, lc.CacheTime, source), nil
+		},
+	},
+	"taxii": {
+		untyped: true,
+		remote:  true,
+		build: func(lc ListConfig, _ lists.DataSource, fetcher *util.Fetcher) (list.List, error) {
+			return lists.NewTAXIIList(lc.Name, lc.CacheTime, lc.URL, fetcher), nil
+		},
+	},
+}
+
+//defaultFormat is used for lists which don't set a Format
+const defaultFormat = "lines"
+
+//Parse parses a Config written in the given syntax.
+//Unknown settings are rejected.
+func Parse(data []byte, syntax Syntax) (*Config, error) {
+	config := new(Config)
+	switch syntax {
+	case SyntaxYAML:
+		err := yaml.UnmarshalStrict(data, config)
+		if err != nil {
+			return nil, err
+		}
+	case SyntaxJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(config)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config syntax %q", syntax)
+	}
+	return config, nil
+}
+
+//LoadFile loads a Config from a .yaml, .yml, or .json file. Relative
+//list paths and http.caFile are resolved against the directory holding
+//the file.
+func LoadFile(path string) (*Config, error) {
+	var syntax Syntax
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		syntax = SyntaxYAML
+	case ".json":
+		syntax = SyntaxJSON
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml, or .json", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(data, syntax)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i := range config.Lists {
+		config.Lists[i].File = resolvePath(path, config.Lists[i].File)
+	}
+	config.HTTP.CAFile = resolvePath(path, config.HTTP.CAFile)
+	return config, nil
+}
+
+//resolvePath resolves a relative path read from a config file against
+//the directory holding the config file
+func resolvePath(configPath, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+//LoadLists loads a Config from a file and builds its lists
+func LoadLists(path string) ([]list.List, error) {
+	config, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.BuildLists()
+}
+
+//Validate checks the Config for problems. Returns a *ValidationError
+//holding every problem found, or nil if the Config is valid.
+func (c *Config) Validate() error {
+	var errs []*FieldError
+	addError := func(field, listName, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{Field: field, List: listName, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.HTTP.Timeout != "" {
+		if timeout, err := time.ParseDuration(c.HTTP.Timeout); err != nil || timeout <= 0 {
+			addError("http.timeout", "", "must be a positive duration such as 30s")
+		}
+	}
+	if c.HTTP.Proxy != "" {
+		if _, err := url.Parse(c.HTTP.Proxy); err != nil {
+			addError("http.proxy", "", "invalid url: %v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, lc := range c.Lists {
+		field := func(name string) string { return fmt.Sprintf("lists[%d].%s", i, name) }
+
+		if lc.Name == "" {
+			addError(field("name"), "", "must be set")
+		} else if names[lc.Name] {
+			addError(field("name"), lc.Name, "is used by another list")
+		}
+		names[lc.Name] = true
+
+		switch {
+		case lc.URL == "" && lc.File == "":
+			addError(field("url"), lc.Name, "either url or file must be set")
+		case lc.URL != "" && lc.File != "":
+			addError(field("url"), lc.Name, "url and file must not both be set")
+		case lc.URL != "":
+			parsed, err := url.Parse(lc.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				addError(field("url"), lc.Name, "must be an http or https url")
+			}
+		}
+
+		format := lc.Format
+		if format == "" {
+			format = defaultFormat
+		}
+		builder, ok := formats[format]
+		if !ok {
+			addError(field("format"), lc.Name, "unknown format %q, expected one of %s",
+				lc.Format, strings.Join(formatNames(), ", "))
+		}
+
+		switch {
+		case ok && builder.untyped:
+			if lc.Type != "" {
+				addError(field("type"), lc.Name, "must not be set for format %s", format)
+			}
+		case lc.Type == "":
+			addError(field("type"), lc.Name, "must be set")
+		case !list.KnownEntryType(list.BlacklistedEntryType(lc.Type)):
+			addError(field("type"), lc.Name, "unknown entry type %q", lc.Type)
+		}
+		if ok && builder.remote {
+			if lc.File != "" {
+				addError(field("file"), lc.Name, "must not be set for format %s, which requires a url", format)
+			}
+			if lc.Compression != "" && lc.Compression != "none" {
+				addError(field("compression"), lc.Name, "must not be set for format %s", format)
+			}
+		}
+
+		if lc.CacheTime < 0 {
+			addError(field("cacheTime"), lc.Name, "must not be negative")
+		}
+
+		switch lc.Compression {
+		case "", "none", "gzip", "zip":
+		default:
+			addError(field("compression"), lc.Name, "unknown compression %q, expected none, gzip, or zip", lc.Compression)
+		}
+
+		if ok {
+			for option := range lc.Options {
+				if !util.Contains(builder.options, option) {
+					addError(field("options."+option), lc.Name, "unknown option for format %s", format)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+//BuildLists validates the Config and creates its lists
+func (c *Config) BuildLists() ([]list.List, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := c.HTTP.buildFetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	built := make([]list.List, 0, len(c.Lists))
+	for i, lc := range c.Lists {
+		format := lc.Format
+		if format == "" {
+			format = defaultFormat
+		}
+		builder := formats[format]
+
+		var source lists.DataSource
+		if !builder.remote {
+			switch {
+			case lc.URL != "" && builder.streaming:
+				source = lists.NewStreamingWebSource(lc.URL, fetcher)
+			case lc.URL != "":
+				source = lists.NewWebSource(lc.URL, fetcher)
+			case builder.streaming:
+				source = lists.NewStreamingFileSource(lc.File)
+			default:
+				source = lists.NewFileSource(lc.File)
+			}
+			switch lc.Compression {
+			case "gzip":
+				source = lists.NewGzipSource(source)
+			case "zip":
+				source = lists.NewZipSource(source)
+			}
+		}
+
+		l, err := builder.build(lc, source, fetcher)
+		if err != nil {
+			return nil, &ValidationError{Errors: []*FieldError{{
+				Field:   fmt.Sprintf("lists[%d].options", i),
+				List:    lc.Name,
+				Message: err.Error(),
+			}}}
+		}
+		meta := l.GetMetadata()
+		//formats such as adblock match subdomains by default
+		meta.SuffixMatch = meta.SuffixMatch || lc.SuffixMatch
+		l.SetMetadata(meta)
+		built = append(built, l)
+	}
+	return built, nil
+}
+
+//buildFetcher creates the Fetcher used by lists with a URL.
+//Returns util.DefaultFetcher if no settings are changed.
+func (h HTTPConfig) buildFetcher() (*util.Fetcher, error) {
+	if h.Proxy == "" && h.Timeout == "" && h.UserAgent == "" && len(h.Headers) == 0 && h.CAFile == "" {
+		return util.DefaultFetcher, nil
+	}
+
+	opts := util.FetcherOptions{
+		ProxyURL:  h.Proxy,
+		UserAgent: h.UserAgent,
+		Headers:   make(http.Header),
+	}
+	if h.Timeout != "" {
+		//the timeout is checked by Validate
+		opts.Timeout, _ = time.ParseDuration(h.Timeout)
+	}
+	for key, value := range h.Headers {
+		opts.Headers.Set(key, value)
+	}
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading http.caFile: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("http.caFile does not hold any PEM certificates")
+		}
+		opts.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	return util.NewFetcher(opts)
+}
+
+//buildCSVList creates a csv list. The options are:
+//
+//	index       the column holding the index of each entry (required)
+//	columns     comma separated columns stored in ExtraData, written as
+//	            [key=]column[:type], e.g. "port=2:int,malware"
+//	header      true if the first row holds the names of the columns
+//	delimiter   the field delimiter, e.g. ";" or "tab". Defaults to ",".
+//	comment     rows starting with this character are skipped, e.g. "#"
+//	lazyQuotes  true to accept quotes in unquoted fields
+//	trimSpace   true to trim the whitespace surrounding each field
+//	timeLayout  the layout time columns are parsed with
+func buildCSVList(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+	opts := lists.CSVOptions{IndexColumn: lc.Options["index"]}
+	var err error
+	for _, option := range []struct {
+		name  string
+		value *bool
+	}{
+		{"header", &opts.Header},
+		{"lazyQuotes", &opts.LazyQuotes},
+		{"trimSpace", &opts.TrimSpace},
+	} {
+		if value, ok := lc.Options[option.name]; ok {
+			*option.value, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", option.name)
+			}
+		}
+	}
+
+	if delimiter, ok := lc.Options["delimiter"]; ok {
+		if delimiter == "tab" || delimiter == `\t` {
+			delimiter = "\t"
+		}
+		opts.Delimiter, err = singleRune("delimiter", delimiter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if comment, ok := lc.Options["comment"]; ok {
+		opts.Comment, err = singleRune("comment", comment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range splitOption(lc.Options["columns"]) {
+		var column lists.CSVColumn
+		if i := strings.Index(spec, "="); i >= 0 {
+			column.Key, spec = spec[:i], spec[i+1:]
+		}
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			spec, column.Type = spec[:i], lists.ColumnType(spec[i+1:])
+		}
+		column.Column = spec
+		column.TimeLayout = lc.Options["timeLayout"]
+		opts.Columns = append(opts.Columns, column)
+	}
+
+	return lists.NewCSVListFromSource(list.BlacklistedEntryType(lc.Type), lc.Name, lc.CacheTime, opts, source)
+}
+
+//buildJSONList creates a json list, which reads JSON arrays or JSON Lines.
+//Paths are dot separated, e.g. "indicator.ip". The options are:
+//
+//	index    the path of the indexes of the list's type (required)
+//	indexes  comma separated paths of indexes of other types, written
+//	         as type=path, e.g. "hostname=indicator.domain"
+//	fields   comma separated paths stored in ExtraData, written as
+//	         [key=]path, e.g. "family=malware.family,tags"
+//	items    the path of the array holding the documents, e.g. "data"
+func buildJSONList(lc ListConfig, source lists.DataSource, _ *util.Fetcher) (list.List, error) {
+	index, ok := lc.Options["index"]
+	if !ok {
+		return nil, errors.New("index must be set")
+	}
+	opts := lists.JSONOptions{
+		Indexes:   []lists.JSONIndex{{Type: list.BlacklistedEntryType(lc.Type), Path: index}},
+		ItemsPath: lc.Options["items"],
+	}
+	for _, spec := range splitOption(lc.Options["indexes"]) {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("index %q must be written as type=path", spec)
+		}
+		opts.Indexes = append(opts.Indexes, lists.JSONIndex{
+			Type: list.BlacklistedEntryType(spec[:i]),
+			Path: spec[i+1:],
+		})
+	}
+	for _, spec := range splitOption(lc.Options["fields"]) {
+		var field lists.JSONField
+		if i := strings.Index(spec, "="); i >= 0 {
+			field.Key, spec = spec[:i], spec[i+1:]
+		}
+		field.Path = spec
+		opts.Fields = append(opts.Fields, field)
+	}
+	return lists.NewJSONListFromSource(lc.Name, lc.CacheTime, opts, source)
+}
+
+//splitOption splits a comma separated option into its trimmed values
+func splitOption(value string) []string {
+	if value == "" {
+		return nil
+	}
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+//singleRune returns the only character of an option's value
+func singleRune(option, value string) (rune, error) {
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("%s must be a single character", option)
+	}
+	return runes[0], nil
+}
+
+//formatNames returns the names of the known formats in sorted order
+func formatNames() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"compress/gzip"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestLoadYAMLAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ips.txt"), []byte("10.10.10.10\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped, err := os.Create(filepath.Join(dir, "hosts.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(gzipped)
+	writer.Write([]byte("example.com\n"))
+	writer.Close()
+	gzipped.Close()
+
+	yamlPath := filepath.Join(dir, "lists.yaml")
+	err = os.WriteFile(yamlPath, []byte(`
+http:
+  timeout: 30s
+  userAgent: test
+lists:
+  - name: ips
+    file: ips.txt
+    type: ip
+    cacheTime: 3600
+  - name: hosts
+    file: hosts.txt.gz
+    type: hostname
+    compression: gzip
+    suffixMatch: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPath := filepath.Join(dir, "lists.json")
+	err = os.WriteFile(jsonPath, []byte(`{
+		"lists": [
+			{"name": "ips", "file": "ips.txt", "type": "ip", "cacheTime": 3600},
+			{"name": "hosts", "file": "hosts.txt.gz", "type": "hostname",
+			 "compression": "gzip", "suffixMatch": true}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		lists, err := LoadLists(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(lists) != 2 {
+			t.Fatalf("%s: expected 2 lists, got %d", path, len(lists))
+		}
+		if meta := lists[0].GetMetadata(); meta.Name != "ips" || meta.CacheTime != 3600 {
+			t.Errorf("%s: unexpected metadata %+v", path, meta)
+		}
+		if !lists[1].GetMetadata().SuffixMatch {
+			t.Errorf("%s: suffix matching was not enabled", path)
+		}
+
+		b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+		b.SetLists(lists...)
+		b.Update()
+
+		ip := "10.10.10.10"
+		if len(b.CheckEntries(list.BlacklistedIPType, ip)[ip]) != 1 {
+			t.Errorf("%s: %s was not found", path, ip)
+		}
+		host := "www.example.com"
+		if len(b.CheckEntries(list.BlacklistedHostnameType, host)[host]) != 1 {
+			t.Errorf("%s: %s was not found", path, host)
+		}
+	}
+}
+
+func TestCAFileRelativeToConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.10.10.10\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err := os.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "lists.yaml")
+	err = os.WriteFile(path, []byte(`
+http:
+  caFile: ca.pem
+lists:
+  - name: ips
+    url: `+server.URL+`
+    type: ip
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HTTP.CAFile != filepath.Join(dir, "ca.pem") {
+		t.Errorf("caFile was resolved to %s", config.HTTP.CAFile)
+	}
+
+	//the list is only fetched if the server's certificate is trusted
+	lists, err := config.BuildLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+	b.SetLists(lists...)
+	b.Update()
+	ip := "10.10.10.10"
+	if len(b.CheckEntries(list.BlacklistedIPType, ip)[ip]) != 1 {
+		t.Errorf("%s was not found", ip)
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	_, err := Parse([]byte("lists:\n  - name: a\n    uri: http://example.com\n"), SyntaxYAML)
+	if err == nil {
+		t.Error("unknown yaml field was accepted")
+	}
+	_, err = Parse([]byte(`{"lists": [{"name": "a", "uri": "http://example.com"}]}`), SyntaxJSON)
+	if err == nil {
+		t.Error("unknown json field was accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{
+		HTTP: HTTPConfig{Timeout: "soon"},
+		Lists: []ListConfig{
+			{Name: "a", URL: "http://example.com/a.txt", Type: "ip"},
+			{Name: "a", URL: "ftp://example.com/b.txt", Type: "ip"},
+			{File: "c.txt", Type: "ip"},
+			{Name: "d", Type: "ip"},
+			{Name: "e", File: "e.txt", Format: "xml", Type: "email"},
+			{Name: "f", File: "f.txt", Type: "ip", CacheTime: -1, Compression: "bzip2"},
+			{Name: "g", File: "g.txt", Type: "ip", Options: map[string]string{"delimiter": ","}},
+		},
+	}
+
+	expected := []string{
+		"http.timeout",
+		"lists[1].name",
+		"lists[1].url",
+		"lists[2].name",
+		"lists[3].url",
+		"lists[4].format",
+		"lists[4].type",
+		"lists[5].cacheTime",
+		"lists[5].compression",
+		"lists[6].options.delimiter",
+	}
+
+	_, err := config.BuildLists()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if len(validationErr.Errors) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %v", len(expected), len(validationErr.Errors), err)
+	}
+	for i, field := range expected {
+		if validationErr.Errors[i].Field != field {
+			t.Errorf("error %d: expected field %s, got %s", i, field, validationErr.Errors[i].Field)
+		}
+	}
+	if !strings.Contains(err.Error(), "lists[1].name (a): is used by another list") {
+		t.Errorf("unexpected message: %v", err)
+	}
+}
+
+func TestCSVFormat(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "feed.tsv"), []byte("# ip\tport\n10.10.10.10\t443\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Lists: []ListConfig{{
+		Name:   "feed",
+		File:   filepath.Join(dir, "feed.tsv"),
+		Format: "csv",
+		Type:   "ip",
+		Options: map[string]string{
+			"index":     "0",
+			"columns":   "port=1:int",
+			"delimiter": "tab",
+			"comment":   "#",
+		},
+	}}}
+	lists, err := config.BuildLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+	b.SetLists(lists...)
+	b.Update()
+	ip := "10.10.10.10"
+	results := b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+	if len(results) != 1 || results[0].ExtraData["port"] != int64(443) {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	//options which the csv list rejects are reported against the list
+	config.Lists[0].Options["columns"] = "port=1:uint"
+	_, err = config.BuildLists()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Field != "lists[0].options" {
+		t.Errorf("unexpected error %v", err)
+	}
+	config.Lists[0].Options["comment"] = "//"
+	_, err = config.BuildLists()
+	if err == nil || !strings.Contains(err.Error(), "comment must be a single character") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "feed.json"), []byte(`{"data": [
+		{"ip": "10.10.10.10", "domain": "evil.com", "malware": {"family": "Emotet"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Lists: []ListConfig{{
+		Name:   "feed",
+		File:   filepath.Join(dir, "feed.json"),
+		Format: "json",
+		Type:   "ip",
+		Options: map[string]string{
+			"items":   "data",
+			"index":   "ip",
+			"indexes": "hostname=domain",
+			"fields":  "family=malware.family",
+		},
+	}}}
+	lists, err := config.BuildLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blacklist.NewBlacklist(database.NewMemoryDB(), func(err error) { t.Error(err) })
+	b.SetLists(lists...)
+	b.Update()
+	ip := "10.10.10.10"
+	results := b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+	if len(results) != 1 || results[0].ExtraData["family"] != "Emotet" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	host := "evil.com"
+	if len(b.CheckEntries(list.BlacklistedHostnameType, host)[host]) != 1 {
+		t.Errorf("%s was not found", host)
+	}
+
+	config.Lists[0].Options["indexes"] = "domain"
+	_, err = config.BuildLists()
+	if err == nil || !strings.Contains(err.Error(), "type=path") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUntypedFormats(t *testing.T) {
+	config := &Config{Lists: []ListConfig{
+		{Name: "bundle", File: "bundle.json", Format: "stix"},
+		{Name: "collection", URL: "https://example.com/api/collections/1/", Format: "taxii"},
+		{Name: "events", URL: "https://misp.example.com/events/restSearch/json", Format: "misp"},
+		{Name: "hosts", URL: "https://example.com/hosts", Format: "hosts"},
+		{Name: "filters", URL: "https://example.com/filters.txt", Format: "adblock"},
+	}}
+	lists, err := config.BuildLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 5 || len(lists[0].GetMetadata().Types) != 4 || len(lists[2].GetMetadata().Types) != 4 {
+		t.Errorf("unexpected lists %+v", lists)
+	}
+	if lists[3].GetMetadata().SuffixMatch || !lists[4].GetMetadata().SuffixMatch {
+		t.Error("only the adblock list should match subdomains")
+	}
+
+	config.Lists[0].Type = "ip"
+	config.Lists[1].URL = ""
+	config.Lists[1].File = "bundle.json"
+	err = config.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Errors) != 2 ||
+		validationErr.Errors[0].Field != "lists[0].type" || validationErr.Errors[1].Field != "lists[1].file" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
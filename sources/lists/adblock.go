@@ -0,0 +1,80 @@
+package lists
+
+import (
+	"strings"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//adblockOptions holds the rule options which don't narrow the requests
+//a rule blocks, so rules using them still block their whole domain
+var adblockOptions = map[string]bool{
+	"important": true,
+	"all":       true,
+}
+
+//NewAdblockListFromSource returns a new hostnameList object which reads an
+//Adblock style filter list from the given DataSource. Only rules blocking
+//a whole domain, such as "||bad.com^", produce hostname entries. Since
+//these rules block the subdomains of their domain as well, the list's
+//Metadata has SuffixMatch set.
+//
+//Comments, cosmetic rules (e.g. "bad.com##.ad"), exception rules
+//(e.g. "@@||good.com^"), rules matching parts of URLs, and rules with
+//options which narrow the requests they block (e.g. "$third-party")
+//are ignored.
+func NewAdblockListFromSource(name string, cacheTime int64, source DataSource) list.List {
+	return &hostnameList{
+		meta: list.Metadata{
+			Types:       []list.BlacklistedEntryType{list.BlacklistedHostnameType},
+			Name:        name,
+			CacheTime:   cacheTime,
+			SuffixMatch: true,
+		},
+		dataSource: source,
+		parseLine:  parseAdblockLine,
+	}
+}
+
+//NewAdblockWebList returns a new hostnameList object which downloads an
+//Adblock style filter list from the given url. util.DefaultFetcher is used
+//if fetcher is nil.
+func NewAdblockWebList(name string, cacheTime int64, url string, fetcher *util.Fetcher) list.List {
+	return NewAdblockListFromSource(name, cacheTime, NewWebSource(url, fetcher))
+}
+
+//parseAdblockLine returns the domain blocked by an Adblock rule, or nil if
+//the line isn't a rule blocking a whole domain
+func parseAdblockLine(line string) []string {
+	line = strings.TrimSpace(line)
+	//domain rules start with ||, which also rules out comments (!),
+	//headers ([Adblock Plus 2.0]), and exception rules (@@)
+	if !strings.HasPrefix(line, "||") {
+		return nil
+	}
+	//cosmetic rules hold a #, e.g. ##, #@#, or #?#
+	if strings.Contains(line, "#") {
+		return nil
+	}
+	rule := line[2:]
+	if optionsStart := strings.IndexByte(rule, '$'); optionsStart >= 0 {
+		for _, option := range strings.Split(rule[optionsStart+1:], ",") {
+			if !adblockOptions[strings.TrimSpace(option)] {
+				return nil
+			}
+		}
+		rule = rule[:optionsStart]
+	}
+	//the domain must be followed by a separator, and optionally the end
+	//of the address, so the rule can't match longer domains or paths
+	rule = strings.TrimSuffix(rule, "|")
+	if !strings.HasSuffix(rule, "^") {
+		return nil
+	}
+	domain := rule[:len(rule)-1]
+	if domain == "" || strings.ContainsAny(domain, "*^|/:?=&") {
+		return nil
+	}
+	return []string{domain}
+}
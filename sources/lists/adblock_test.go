@@ -0,0 +1,66 @@
+package lists
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestAdblockListBL(t *testing.T) {
+	data := `[Adblock Plus 2.0]
+! Title: example filters
+||bad.com^
+||Tracker.Example.NET^$important
+@@||good.com^
+example.org##.ad-banner
+||evil.com/ads/*
+`
+	adblockList := NewAdblockListFromSource("adblock", 86400, stringSource(data))
+	if !adblockList.GetMetadata().SuffixMatch {
+		t.Error("adblock lists should match subdomains")
+	}
+	b, reports := updateTestList(t, adblockList, nil)
+	if len(reports) != 1 || reports[0].Added != 2 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	results := b.CheckEntries(list.BlacklistedHostnameType, "www.bad.com", "tracker.example.net",
+		"good.com", "example.org", "evil.com")
+	for _, host := range []string{"www.bad.com", "tracker.example.net"} {
+		if len(results[host]) != 1 {
+			t.Errorf("%s was not found", host)
+		}
+	}
+	for _, host := range []string{"good.com", "example.org", "evil.com"} {
+		if len(results[host]) != 0 {
+			t.Errorf("%s was found", host)
+		}
+	}
+}
+
+func TestParseAdblockLine(t *testing.T) {
+	for _, c := range []struct {
+		line     string
+		expected []string
+	}{
+		{"||bad.com^", []string{"bad.com"}},
+		{" ||bad.com^| ", []string{"bad.com"}},
+		{"||bad.com^$important,all", []string{"bad.com"}},
+		{"||bad.com^$third-party", nil},
+		{"||bad.com", nil},
+		{"||*.bad.com^", nil},
+		{"||bad.com/path^", nil},
+		{"@@||bad.com^", nil},
+		{"bad.com##.ad", nil},
+		{"||bad.com^#@#.ad", nil},
+		{"! ||bad.com^", nil},
+		{"|https://bad.com^", nil},
+		{"||^", nil},
+	} {
+		hostnames := parseAdblockLine(c.line)
+		if !reflect.DeepEqual(hostnames, c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.line, c.expected, hostnames)
+		}
+	}
+}
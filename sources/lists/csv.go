@@ -0,0 +1,347 @@
+package lists
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//ColumnType selects how the values of a CSV column are converted
+//before they are stored in ExtraData
+type ColumnType string
+
+const (
+	//ColumnString stores values as strings
+	ColumnString ColumnType = "string"
+	//ColumnInt stores values as int64s
+	ColumnInt ColumnType = "int"
+	//ColumnFloat stores values as float64s
+	ColumnFloat ColumnType = "float"
+	//ColumnBool stores values as bools. Accepts the values
+	//understood by strconv.ParseBool.
+	ColumnBool ColumnType = "bool"
+	//ColumnTime parses values with the column's TimeLayout and stores
+	//them as unix timestamps
+	ColumnTime ColumnType = "time"
+)
+
+type (
+	//CSVColumn maps a column of a CSV list into the ExtraData of its entries
+	CSVColumn struct {
+		//Column is the name of the column in the header row, or its zero
+		//based position if the list has no header row
+		Column string
+		//Key is the ExtraData key the values are stored under.
+		//Defaults to Column.
+		Key string
+		//Type selects how values are converted. Defaults to ColumnString.
+		Type ColumnType
+		//TimeLayout is the layout ColumnTime values are parsed with.
+		//Defaults to time.RFC3339.
+		TimeLayout string
+	}
+
+	//CSVOptions configures how a CSV list is parsed
+	CSVOptions struct {
+		//IndexColumn is the column holding the index of each entry. It is
+		//the name of the column in the header row, or its zero based
+		//position if the list has no header row.
+		IndexColumn string
+		//Columns maps the other columns into ExtraData. Empty values
+		//are not stored.
+		Columns []CSVColumn
+		//Header causes the first row to be read as the names of the columns
+		Header bool
+		//Delimiter separates the fields of a row. Defaults to ','.
+		//Use '\t' for TSV lists.
+		Delimiter rune
+		//Comment causes rows starting with it to be skipped, e.g. '#'.
+		//Rows are not skipped if Comment is 0.
+		Comment rune
+		//LazyQuotes allows quotes to appear in unquoted fields and
+		//unescaped quotes to appear in quoted fields
+		LazyQuotes bool
+		//TrimSpace removes the whitespace surrounding each field
+		TrimSpace bool
+	}
+
+	//csvList is a list with one entry per row of CSV data
+	csvList struct {
+		meta       list.Metadata
+		opts       CSVOptions
+		dataSource DataSource
+	}
+
+	//csvLayout holds the positions of the columns used by a csvList
+	csvLayout struct {
+		index   int
+		columns []int
+	}
+)
+
+//NewCSVListFromSource returns a new csvList object which reads its data
+//from the given DataSource. Returns an error if opts are invalid.
+func NewCSVListFromSource(entryType list.BlacklistedEntryType, name string,
+	cacheTime int64, opts CSVOptions, source DataSource) (list.List, error) {
+	if opts.Delimiter == 0 {
+		opts.Delimiter = ','
+	}
+	err := validateCSVOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for csv list %s: %w", name, err)
+	}
+	return &csvList{
+		meta: list.Metadata{
+			Types:     []list.BlacklistedEntryType{entryType},
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		opts:       opts,
+		dataSource: source,
+	}, nil
+}
+
+//NewCSVWebList returns a new csvList object which downloads its data from
+//the given url. util.DefaultFetcher is used if fetcher is nil.
+func NewCSVWebList(entryType list.BlacklistedEntryType, name string,
+	cacheTime int64, opts CSVOptions, url string, fetcher *util.Fetcher) (list.List, error) {
+	return NewCSVListFromSource(entryType, name, cacheTime, opts, NewWebSource(url, fetcher))
+}
+
+//validateCSVOptions checks that opts can be used to parse a list
+func validateCSVOptions(opts CSVOptions) error {
+	if opts.Delimiter == opts.Comment || opts.Delimiter == '"' ||
+		opts.Delimiter == '\r' || opts.Delimiter == '\n' {
+		return fmt.Errorf("invalid delimiter %q", opts.Delimiter)
+	}
+	if opts.Comment == '"' || opts.Comment == '\r' || opts.Comment == '\n' {
+		return fmt.Errorf("invalid comment %q", opts.Comment)
+	}
+	if opts.IndexColumn == "" {
+		return errors.New("the index column must be set")
+	}
+	columns := append([]string{opts.IndexColumn}, make([]string, len(opts.Columns))...)
+	for i, column := range opts.Columns {
+		if column.Column == "" {
+			return errors.New("every column must be set")
+		}
+		switch column.Type {
+		case "", ColumnString, ColumnInt, ColumnFloat, ColumnBool, ColumnTime:
+		default:
+			return fmt.Errorf("unknown type %q for column %s", column.Type, column.Column)
+		}
+		columns[i+1] = column.Column
+	}
+	if !opts.Header {
+		for _, column := range columns {
+			position, err := strconv.Atoi(column)
+			if err != nil || position < 0 {
+				return fmt.Errorf("column %q must be a position since the list has no header", column)
+			}
+		}
+	}
+	return nil
+}
+
+//GetMetadata returns the Metadata associated with this blacklist
+func (c *csvList) GetMetadata() list.Metadata {
+	return c.meta
+}
+
+//SetMetadata sets the Metadata associated with this blacklist
+func (c *csvList) SetMetadata(meta list.Metadata) {
+	c.meta = meta
+}
+
+//FetchData fetches the BlacklistedEntries associated with this list.
+//Rows which can't be parsed are reported as record errors and skipped.
+func (c *csvList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	entryType := c.GetMetadata().Types[0]
+	defer close(entryMap[entryType])
+	reader, err := c.dataSource(ctx, &c.meta)
+	if errors.Is(err, list.ErrNotModified) {
+		errorsOut <- err
+		return
+	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, c.meta.Name, entryType, "", err)
+		return
+	}
+	defer reader.Close()
+
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = c.opts.Delimiter
+	csvReader.Comment = c.opts.Comment
+	csvReader.LazyQuotes = c.opts.LazyQuotes
+	csvReader.TrimLeadingSpace = c.opts.TrimSpace
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+
+	parseError := func(err error) {
+		errorsOut <- list.WrapError(list.CategoryParse, c.meta.Name, entryType, "", err)
+	}
+	skipRecord := func(index string, err error) {
+		errorsOut <- list.WrapError(list.CategoryRecord, c.meta.Name, entryType, index, err)
+	}
+
+	var layout csvLayout
+	if c.opts.Header {
+		header, err := csvReader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			parseError(fmt.Errorf("reading header: %w", err))
+			return
+		}
+		layout, err = c.headerLayout(header)
+		if err != nil {
+			parseError(err)
+			return
+		}
+	} else {
+		layout = c.positionLayout()
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return
+		}
+		var csvErr *csv.ParseError
+		if errors.As(err, &csvErr) {
+			//the reader moves past malformed rows
+			skipRecord("", err)
+			continue
+		}
+		if err != nil {
+			parseError(err)
+			return
+		}
+
+		entry, err := c.parseRecord(record, layout)
+		if err != nil {
+			line, _ := csvReader.FieldPos(0)
+			skipRecord(entry.Index, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		if entry.Index == "" {
+			continue
+		}
+		if !list.SendEntry(ctx, entryMap[entryType], entry) {
+			errorsOut <- list.WrapError(list.CategoryFetch, c.meta.Name, entryType, "", ctx.Err())
+			return
+		}
+	}
+}
+
+//headerLayout finds the positions of the columns named in the header row
+func (c *csvList) headerLayout(header []string) (csvLayout, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+	find := func(name string) (int, error) {
+		position, ok := positions[name]
+		if !ok {
+			return 0, fmt.Errorf("column %q is missing from the header", name)
+		}
+		return position, nil
+	}
+
+	var layout csvLayout
+	var err error
+	layout.index, err = find(c.opts.IndexColumn)
+	if err != nil {
+		return layout, err
+	}
+	layout.columns = make([]int, len(c.opts.Columns))
+	for i, column := range c.opts.Columns {
+		layout.columns[i], err = find(column.Column)
+		if err != nil {
+			return layout, err
+		}
+	}
+	return layout, nil
+}
+
+//positionLayout reads the positions of the columns from their names.
+//The names are checked by NewCSVListFromSource.
+func (c *csvList) positionLayout() csvLayout {
+	var layout csvLayout
+	layout.index, _ = strconv.Atoi(c.opts.IndexColumn)
+	layout.columns = make([]int, len(c.opts.Columns))
+	for i, column := range c.opts.Columns {
+		layout.columns[i], _ = strconv.Atoi(column.Column)
+	}
+	return layout
+}
+
+//parseRecord creates an entry from a row of the list
+func (c *csvList) parseRecord(record []string, layout csvLayout) (list.BlacklistedEntry, error) {
+	field := func(position int) string {
+		if position >= len(record) {
+			return ""
+		}
+		if c.opts.TrimSpace {
+			return strings.TrimSpace(record[position])
+		}
+		return record[position]
+	}
+
+	entry := list.NewBlacklistedEntry(field(layout.index), c)
+	if layout.index >= len(record) {
+		return entry, fmt.Errorf("row has %d fields, the index column is missing", len(record))
+	}
+	for i, column := range c.opts.Columns {
+		value := field(layout.columns[i])
+		if value == "" {
+			continue
+		}
+		converted, err := convertCSVValue(value, column)
+		if err != nil {
+			return entry, fmt.Errorf("column %s: %w", column.Column, err)
+		}
+		key := column.Key
+		if key == "" {
+			key = column.Column
+		}
+		entry.ExtraData[key] = converted
+	}
+	return entry, nil
+}
+
+//convertCSVValue converts a value according to the column's Type
+func convertCSVValue(value string, column CSVColumn) (interface{}, error) {
+	switch column.Type {
+	case ColumnInt:
+		return strconv.ParseInt(value, 10, 64)
+	case ColumnFloat:
+		return strconv.ParseFloat(value, 64)
+	case ColumnBool:
+		return strconv.ParseBool(value)
+	case ColumnTime:
+		layout := column.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			return nil, err
+		}
+		return parsed.Unix(), nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,209 @@
+package lists
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestCSVListBL(t *testing.T) {
+	data := `# exported from a feed
+first_seen,dst_ip,dst_port,online,malware
+"2021-01-02T03:04:05Z",10.10.10.10,443,true,"Emotet, epoch 4"
+2021-01-03T00:00:00Z,10.10.10.11,,false,Dridex
+2021-01-04T00:00:00Z,10.10.10.12,not a port,true,TrickBot
+`
+	csvList, err := NewCSVListFromSource(list.BlacklistedIPType, "csv", 86400, CSVOptions{
+		IndexColumn: "dst_ip",
+		Header:      true,
+		Comment:     '#',
+		Columns: []CSVColumn{
+			{Column: "first_seen", Type: ColumnTime},
+			{Column: "dst_port", Key: "port", Type: ColumnInt},
+			{Column: "online", Type: ColumnBool},
+			{Column: "malware"},
+		},
+	}, stringSource(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	b, reports := updateTestList(t, csvList, &errs)
+	if len(reports) != 1 || reports[0].Added != 2 || reports[0].Skipped != 1 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	//the row with an invalid port is reported and skipped
+	var blErr *list.BlacklistError
+	if len(errs) != 1 || !errors.As(errs[0], &blErr) ||
+		blErr.Category != list.CategoryRecord || blErr.Index != "10.10.10.12" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	ip := "10.10.10.10"
+	results := b.CheckEntries(list.BlacklistedIPType, ip, "10.10.10.11", "10.10.10.12")
+	if len(results[ip]) != 1 {
+		t.Fatalf("%s was not found", ip)
+	}
+	extra := results[ip][0].ExtraData
+	if extra["first_seen"] != int64(1609556645) || extra["port"] != int64(443) ||
+		extra["online"] != true || extra["malware"] != "Emotet, epoch 4" {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+
+	//empty values are not stored
+	extra = results["10.10.10.11"][0].ExtraData
+	if _, ok := extra["port"]; ok || extra["online"] != false {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+	if len(results["10.10.10.12"]) != 0 {
+		t.Error("the invalid row was stored")
+	}
+}
+
+func TestCSVListRefreshWithBadRowBL(t *testing.T) {
+	data := "10.10.10.10,443\n10.10.10.11,notaport\n"
+	source := func(context.Context, *list.Metadata) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+	csvList, err := NewCSVListFromSource(list.BlacklistedIPType, "csv", 0, CSVOptions{
+		IndexColumn: "0",
+		Columns:     []CSVColumn{{Column: "1", Key: "port", Type: ColumnInt}},
+	}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := database.NewMemoryDB()
+	var errs []error
+	b := newTestBlacklist(t, db, &errs, csvList)
+	reports := b.Update()
+	if len(reports) != 1 || reports[0].Action != blacklist.ActionCreated || reports[0].Skipped != 1 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	//the bad row doesn't stop the list from being cached
+	metas, err := db.GetRegisteredLists(context.Background())
+	if err != nil || len(metas) != 1 || metas[0].LastUpdate == 0 {
+		t.Fatalf("the list was not marked as updated: %+v", metas)
+	}
+
+	//the list is refreshed while it still holds the bad row
+	data += "10.10.10.12,80\n"
+	reports = b.Update()
+	if len(reports) != 1 || reports[0].Action != blacklist.ActionRefreshed ||
+		reports[0].Added != 1 || reports[0].Skipped != 1 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+	ip := "10.10.10.12"
+	if len(b.CheckEntries(list.BlacklistedIPType, ip)[ip]) != 1 {
+		t.Errorf("%s was not stored by the refresh", ip)
+	}
+	if len(errs) != 2 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestCSVListIncrementalExtraDataBL(t *testing.T) {
+	bolt, err := database.NewBoltDB(filepath.Join(t.TempDir(), "rita-bl.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []database.Handle{database.NewMemoryDB(), bolt} {
+		data := "10.10.10.10,443\n10.10.10.11,80\n"
+		source := func(context.Context, *list.Metadata) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data)), nil
+		}
+		csvList, err := NewCSVListFromSource(list.BlacklistedIPType, "csv", 0, CSVOptions{
+			IndexColumn: "0",
+			Columns:     []CSVColumn{{Column: "1", Key: "port", Type: ColumnInt}},
+		}, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := newTestBlacklist(t, db, nil, csvList)
+		b.Update()
+
+		//unchanged entries are left alone
+		reports := b.Update()
+		if len(reports) != 1 || reports[0].Added != 0 || reports[0].Removed != 0 {
+			t.Errorf("unexpected reports %+v", reports)
+		}
+
+		//an entry whose extra data changed is replaced
+		data = "10.10.10.10,8443\n10.10.10.11,80\n"
+		reports = b.Update()
+		if len(reports) != 1 || reports[0].Added != 1 || reports[0].Removed != 1 {
+			t.Errorf("unexpected reports %+v", reports)
+		}
+		ip := "10.10.10.10"
+		results := b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+		if len(results) != 1 || fmt.Sprint(results[0].ExtraData["port"]) != "8443" {
+			t.Errorf("unexpected results %+v", results)
+		}
+	}
+}
+
+func TestTSVListBL(t *testing.T) {
+	data := "evil.com\t 12.5 \n\t1\nbad.com\n"
+	tsvList, err := NewCSVListFromSource(list.BlacklistedHostnameType, "tsv", 86400, CSVOptions{
+		IndexColumn: "0",
+		Delimiter:   '\t',
+		TrimSpace:   true,
+		Columns:     []CSVColumn{{Column: "1", Key: "score", Type: ColumnFloat}},
+	}, stringSource(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := updateTestList(t, tsvList, nil)
+
+	results := b.CheckEntries(list.BlacklistedHostnameType, "evil.com", "bad.com")
+	if len(results["evil.com"]) != 1 || results["evil.com"][0].ExtraData["score"] != 12.5 {
+		t.Errorf("unexpected results for evil.com %+v", results["evil.com"])
+	}
+	if len(results["bad.com"]) != 1 {
+		t.Error("bad.com was not found")
+	}
+}
+
+func TestCSVListMissingHeaderColumnBL(t *testing.T) {
+	csvList, err := NewCSVListFromSource(list.BlacklistedIPType, "csv", 86400, CSVOptions{
+		IndexColumn: "ip",
+		Header:      true,
+	}, stringSource("address\n10.10.10.10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	updateTestList(t, csvList, &errs)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `column "ip" is missing`) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestCSVOptions(t *testing.T) {
+	for _, opts := range []CSVOptions{
+		{},
+		{IndexColumn: "ip"},
+		{IndexColumn: "0", Columns: []CSVColumn{{Column: "name"}}},
+		{IndexColumn: "ip", Header: true, Columns: []CSVColumn{{Column: "port", Type: "uint"}}},
+		{IndexColumn: "0", Delimiter: '"'},
+		{IndexColumn: "0", Delimiter: '#', Comment: '#'},
+	} {
+		_, err := NewCSVListFromSource(list.BlacklistedIPType, "csv", 0, opts, stringSource(""))
+		if err == nil {
+			t.Errorf("invalid options %+v were accepted", opts)
+		}
+	}
+}
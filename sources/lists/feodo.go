@@ -1,24 +1,21 @@
 package lists
 
 import (
-	"io"
-	"net/http"
-
 	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
 )
 
+//feodoURL is the location of the feodo tracker ip blocklist
+const feodoURL = "https://feodotracker.abuse.ch/downloads/ipblocklist.txt"
+
+//NewFeodoList returns a list of the botnet c2 servers tracked by
+//feodo tracker
 func NewFeodoList() list.List {
-	return NewLineSeparatedList(
-		list.BlacklistedIPType,
-		"feodo tracker",
-		86400,
-		func() (io.ReadCloser, error) {
-			url := "https://feodotracker.abuse.ch/downloads/ipblocklist.txt"
-			resp, err := http.Get(url)
-			if err != nil {
-				return nil, err
-			}
-			return resp.Body, nil
-		},
-	)
+	return NewFeodoListWithFetcher(util.DefaultFetcher)
+}
+
+//NewFeodoListWithFetcher returns a list of the botnet c2 servers tracked
+//by feodo tracker which is downloaded with the given Fetcher
+func NewFeodoListWithFetcher(fetcher *util.Fetcher) list.List {
+	return NewLineSeparatedWebList(list.BlacklistedIPType, "feodo tracker", 86400, feodoURL, fetcher)
 }
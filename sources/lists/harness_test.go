@@ -0,0 +1,39 @@
+package lists
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//stringSource returns a DataSource which always sends data
+func stringSource(data string) DataSource {
+	return func(context.Context, *list.Metadata) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+}
+
+//newTestBlacklist returns a Blacklist backed by db which holds the given
+//list. Errors are appended to errs, or fail the test if errs is nil.
+func newTestBlacklist(t *testing.T, db database.Handle, errs *[]error, l list.List) *blacklist.Blacklist {
+	handleError := func(err error) { t.Error(err) }
+	if errs != nil {
+		handleError = func(err error) { *errs = append(*errs, err) }
+	}
+	b := blacklist.NewBlacklist(db, handleError)
+	b.SetLists(l)
+	return b
+}
+
+//updateTestList loads the given list into a Blacklist backed by a new
+//memory database and updates it once. Errors are handled as they are by
+//newTestBlacklist. Returns the Blacklist and the reports of the update.
+func updateTestList(t *testing.T, l list.List, errs *[]error) (*blacklist.Blacklist, []blacklist.ListReport) {
+	b := newTestBlacklist(t, database.NewMemoryDB(), errs, l)
+	return b, b.Update()
+}
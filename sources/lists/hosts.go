@@ -0,0 +1,124 @@
+package lists
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//hostsIgnoredNames holds the names which hosts files map onto local and
+//broadcast addresses rather than blocking
+var hostsIgnoredNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+//hostnameList is a list of hostnames pulled out of the lines of a
+//blocklist written in a format such as a hosts file
+type hostnameList struct {
+	meta       list.Metadata
+	dataSource DataSource
+	//parseLine returns the hostnames blocked by a line
+	parseLine func(line string) []string
+}
+
+//NewHostsListFromSource returns a new hostnameList object which reads a
+//hosts file from the given DataSource. Every name following the address
+//of a line produces a hostname entry, e.g. "0.0.0.0 bad.com www.bad.com"
+//produces bad.com and www.bad.com. Comments, and names of local and
+//broadcast addresses such as localhost and broadcasthost, are ignored.
+func NewHostsListFromSource(name string, cacheTime int64, source DataSource) list.List {
+	return &hostnameList{
+		meta: list.Metadata{
+			Types:     []list.BlacklistedEntryType{list.BlacklistedHostnameType},
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		dataSource: source,
+		parseLine:  parseHostsLine,
+	}
+}
+
+//NewHostsWebList returns a new hostnameList object which downloads a hosts
+//file from the given url. util.DefaultFetcher is used if fetcher is nil.
+func NewHostsWebList(name string, cacheTime int64, url string, fetcher *util.Fetcher) list.List {
+	return NewHostsListFromSource(name, cacheTime, NewWebSource(url, fetcher))
+}
+
+//GetMetadata returns the Metadata associated with this blacklist
+func (h *hostnameList) GetMetadata() list.Metadata {
+	return h.meta
+}
+
+//SetMetadata sets the Metadata associated with this blacklist
+func (h *hostnameList) SetMetadata(meta list.Metadata) {
+	h.meta = meta
+}
+
+//FetchData fetches the BlacklistedEntries associated with this list.
+//Lines which don't block a hostname are skipped.
+func (h *hostnameList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	entryType := list.BlacklistedHostnameType
+	defer close(entryMap[entryType])
+	reader, err := h.dataSource(ctx, &h.meta)
+	if errors.Is(err, list.ErrNotModified) {
+		errorsOut <- err
+		return
+	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, h.meta.Name, entryType, "", err)
+		return
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		for _, hostname := range h.parseLine(scanner.Text()) {
+			//hostnames are case insensitive and may be fully qualified
+			hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+			if hostname == "" {
+				continue
+			}
+			if !list.SendEntry(ctx, entryMap[entryType], list.NewBlacklistedEntry(hostname, h)) {
+				errorsOut <- list.WrapError(list.CategoryFetch, h.meta.Name, entryType, "", ctx.Err())
+				return
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		errorsOut <- list.WrapError(list.CategoryParse, h.meta.Name, entryType, "", scanner.Err())
+	}
+}
+
+//parseHostsLine returns the names mapped onto an address by a line of a
+//hosts file, leaving out the names of local and broadcast addresses
+func parseHostsLine(line string) []string {
+	if comment := strings.IndexByte(line, '#'); comment >= 0 {
+		line = line[:comment]
+	}
+	fields := strings.Fields(line)
+	//the first field is the address
+	if len(fields) < 2 {
+		return nil
+	}
+	var hostnames []string
+	for _, name := range fields[1:] {
+		if !hostsIgnoredNames[strings.ToLower(name)] {
+			hostnames = append(hostnames, name)
+		}
+	}
+	return hostnames
+}
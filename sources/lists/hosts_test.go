@@ -0,0 +1,84 @@
+package lists
+
+import (
+	"reflect"
+	"testing"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestHostsListBL(t *testing.T) {
+	data := `# Title: example hosts file
+127.0.0.1 localhost
+127.0.0.1 localhost.localdomain
+255.255.255.255 broadcasthost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 0.0.0.0
+
+0.0.0.0 bad.com
+0.0.0.0	Tracker.Example.NET. ads.example.net # trackers
+127.0.0.1 malware.org
+#0.0.0.0 commented.com
+not-a-hosts-line
+`
+	hostsList := NewHostsListFromSource("hosts", 86400, stringSource(data))
+	b, reports := updateTestList(t, hostsList, nil)
+	if len(reports) != 1 || reports[0].Added != 4 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	results := b.CheckEntries(list.BlacklistedHostnameType, "bad.com", "tracker.example.net",
+		"ads.example.net", "malware.org", "localhost", "broadcasthost", "commented.com")
+	for _, host := range []string{"bad.com", "tracker.example.net", "ads.example.net", "malware.org"} {
+		if len(results[host]) != 1 {
+			t.Errorf("%s was not found", host)
+		}
+	}
+	for _, host := range []string{"localhost", "broadcasthost", "commented.com"} {
+		if len(results[host]) != 0 {
+			t.Errorf("%s was found", host)
+		}
+	}
+}
+
+func TestHostsListDuplicatesBL(t *testing.T) {
+	data := "0.0.0.0 bad.com BAD.com\n0.0.0.0 bad.com.\n127.0.0.1 evil.com\n0.0.0.0 evil.com\n"
+	hostsList := NewHostsListFromSource("hosts", 0, stringSource(data))
+	b := newTestBlacklist(t, database.NewMemoryDB(), nil, hostsList)
+	b.SetIncrementalUpdates(false)
+
+	//repeated names are stored once when the list is created and refreshed
+	for _, action := range []blacklist.UpdateAction{blacklist.ActionCreated, blacklist.ActionRefreshed} {
+		reports := b.Update()
+		if len(reports) != 1 || reports[0].Action != action || reports[0].Fetched != 5 ||
+			reports[0].Inserted != 2 || len(reports[0].Errors) != 0 {
+			t.Errorf("unexpected reports %+v", reports)
+		}
+	}
+	results := b.CheckEntries(list.BlacklistedHostnameType, "bad.com", "evil.com")
+	if len(results["bad.com"]) != 1 || len(results["evil.com"]) != 1 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestParseHostsLine(t *testing.T) {
+	for _, c := range []struct {
+		line     string
+		expected []string
+	}{
+		{"0.0.0.0 bad.com", []string{"bad.com"}},
+		{"  0.0.0.0   a.com  b.com#comment", []string{"a.com", "b.com"}},
+		{"127.0.0.1 LocalHost", nil},
+		{"ff02::2 ip6-allrouters", nil},
+		{"bad.com", nil},
+		{"# 0.0.0.0 bad.com", nil},
+		{"", nil},
+	} {
+		hostnames := parseHostsLine(c.line)
+		if !reflect.DeepEqual(hostnames, c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.line, c.expected, hostnames)
+		}
+	}
+}
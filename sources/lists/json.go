@@ -0,0 +1,399 @@
+package lists
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+type (
+	//JSONIndex selects the indexes of a single BlacklistedEntryType from
+	//each document of a JSON list
+	JSONIndex struct {
+		//Type is the type of the indexes
+		Type list.BlacklistedEntryType
+		//Path is the dot separated path of the field holding the index,
+		//e.g. "indicator.ip". Arrays along the path are searched element by
+		//element, so a path may select several indexes from a document.
+		//An empty Path selects the document itself.
+		Path string
+	}
+
+	//JSONField copies a field of each document of a JSON list into ExtraData
+	JSONField struct {
+		//Path is the dot separated path of the field. Arrays along the
+		//path are searched element by element. If several values are
+		//found, they are stored as an array.
+		Path string
+		//Key is the ExtraData key the value is stored under.
+		//Defaults to Path.
+		Key string
+	}
+
+	//JSONOptions configures how a JSON list is parsed
+	JSONOptions struct {
+		//Indexes selects the indexes of each document. A document
+		//produces an entry for every index found.
+		Indexes []JSONIndex
+		//Fields are copied into the ExtraData of every entry produced
+		//by a document
+		Fields []JSONField
+		//ItemsPath is the dot separated path of the array holding the
+		//documents, e.g. "data" for {"data": [...]}. If ItemsPath is empty,
+		//the data must either be an array of documents or a sequence of
+		//documents, such as JSON Lines.
+		ItemsPath string
+	}
+
+	//jsonList is a list with entries taken from a stream of JSON documents.
+	//Documents are decoded one at a time, so the data is never held in
+	//memory as a whole as long as the DataSource streams it.
+	jsonList struct {
+		meta       list.Metadata
+		opts       JSONOptions
+		dataSource DataSource
+	}
+)
+
+//NewJSONListFromSource returns a new jsonList object which reads its data
+//from the given DataSource. The list holds the types of opts.Indexes. Use
+//a streaming DataSource, such as NewStreamingWebSource, to avoid reading
+//the whole document into memory. Returns an error if opts are invalid.
+func NewJSONListFromSource(name string, cacheTime int64, opts JSONOptions,
+	source DataSource) (list.List, error) {
+	if len(opts.Indexes) == 0 {
+		return nil, fmt.Errorf("invalid options for json list %s: at least one index must be set", name)
+	}
+	var types []list.BlacklistedEntryType
+	for _, index := range opts.Indexes {
+		if !list.KnownEntryType(index.Type) {
+			return nil, fmt.Errorf("invalid options for json list %s: unknown entry type %q", name, index.Type)
+		}
+		if !containsType(types, index.Type) {
+			types = append(types, index.Type)
+		}
+	}
+	return &jsonList{
+		meta: list.Metadata{
+			Types:     types,
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		opts:       opts,
+		dataSource: source,
+	}, nil
+}
+
+//NewJSONWebList returns a new jsonList object which streams its data
+//from the given url. util.DefaultFetcher is used if fetcher is nil.
+func NewJSONWebList(name string, cacheTime int64, opts JSONOptions,
+	url string, fetcher *util.Fetcher) (list.List, error) {
+	return NewJSONListFromSource(name, cacheTime, opts, NewStreamingWebSource(url, fetcher))
+}
+
+//GetMetadata returns the Metadata associated with this blacklist
+func (j *jsonList) GetMetadata() list.Metadata {
+	return j.meta
+}
+
+//SetMetadata sets the Metadata associated with this blacklist
+func (j *jsonList) SetMetadata(meta list.Metadata) {
+	j.meta = meta
+}
+
+//FetchData fetches the BlacklistedEntries associated with this list.
+//Documents holding indexes which aren't strings or numbers are reported
+//as record errors and skipped. Malformed JSON stops the fetch.
+func (j *jsonList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	types := j.GetMetadata().Types
+	defer func() {
+		for _, entryType := range types {
+			close(entryMap[entryType])
+		}
+	}()
+	reader, err := j.dataSource(ctx, &j.meta)
+	if errors.Is(err, list.ErrNotModified) {
+		errorsOut <- err
+		return
+	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, j.meta.Name, "", "", err)
+		return
+	}
+	defer reader.Close()
+
+	err = readJSONDocuments(bufio.NewReader(reader), j.opts.ItemsPath, func(decoder *json.Decoder) (bool, error) {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err != nil {
+			return false, err
+		}
+		return j.sendDocument(ctx, document, entryMap, errorsOut), nil
+	})
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryParse, j.meta.Name, "", "", err)
+		return
+	}
+	if ctx.Err() != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, j.meta.Name, "", "", ctx.Err())
+	}
+}
+
+//sendDocument sends the entries found in a document.
+//Returns false if ctx was cancelled.
+func (j *jsonList) sendDocument(ctx context.Context, document interface{},
+	entryMap list.BlacklistedEntryMap, errorsOut chan<- error) bool {
+	var extraData map[string]interface{}
+	for _, index := range j.opts.Indexes {
+		for _, value := range lookupJSONPath(document, splitJSONPath(index.Path), true, nil) {
+			var indexString string
+			switch v := value.(type) {
+			case nil:
+				continue
+			case string:
+				indexString = v
+			case json.Number:
+				indexString = v.String()
+			default:
+				errorsOut <- list.WrapError(list.CategoryRecord, j.meta.Name, index.Type, "",
+					fmt.Errorf("field %q holds a %T rather than an index", index.Path, value))
+				continue
+			}
+			if indexString == "" {
+				continue
+			}
+
+			//every entry of the document shares the same values
+			if extraData == nil {
+				extraData = j.extraData(document)
+			}
+			entry := list.NewBlacklistedEntry(indexString, j)
+			for key, value := range extraData {
+				entry.ExtraData[key] = value
+			}
+			if !list.SendEntry(ctx, entryMap[index.Type], entry) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//extraData returns the values of the list's Fields held by a document
+func (j *jsonList) extraData(document interface{}) map[string]interface{} {
+	extraData := make(map[string]interface{})
+	for _, field := range j.opts.Fields {
+		values := lookupJSONPath(document, splitJSONPath(field.Path), false, nil)
+		key := field.Key
+		if key == "" {
+			key = field.Path
+		}
+		switch len(values) {
+		case 0:
+		case 1:
+			extraData[key] = normalizeJSONValue(values[0])
+		default:
+			extraData[key] = normalizeJSONValue(values)
+		}
+	}
+	return extraData
+}
+
+//readJSONDocuments finds the documents held by the JSON data read from
+//reader and calls handle once for each of them until it returns false or
+//an error. handle must decode exactly one value from the decoder. The
+//documents are read from the array at itemsPath, or if itemsPath is
+//empty, from the top level array or sequence of documents.
+func readJSONDocuments(reader *bufio.Reader, itemsPath string,
+	handle func(decoder *json.Decoder) (bool, error)) error {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+
+	inArray := true
+	if itemsPath != "" {
+		err := findJSONArray(decoder, splitJSONPath(itemsPath))
+		if err != nil {
+			return err
+		}
+	} else {
+		first, err := peekNonSpace(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		inArray = first == '['
+		if inArray {
+			//consume the opening bracket
+			_, err = decoder.Token()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	//More reports false at the end of an array or the end of the data
+	for decoder.More() {
+		ok, err := handle(decoder)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	//More also reports false if reading failed, so the end is checked
+	_, err := decoder.Token()
+	if !inArray && err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//findJSONArray reads tokens from decoder until the opening bracket of
+//the array at path has been read. The values which are passed over are
+//read token by token rather than decoded.
+func findJSONArray(decoder *json.Decoder, path []string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		if token != json.Delim('[') {
+			return errors.New("the items path does not hold an array")
+		}
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected an object holding %q", path[0])
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == path[0] {
+			return findJSONArray(decoder, path[1:])
+		}
+		err = skipJSONValue(decoder)
+		if err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("the items path is missing %q", path[0])
+}
+
+//skipJSONValue reads the next value from decoder without decoding it
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+//peekNonSpace skips the whitespace at the start of reader and returns
+//the next byte without consuming it
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		next, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch next[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.Discard(1)
+		default:
+			return next[0], nil
+		}
+	}
+}
+
+//splitJSONPath splits a dot separated path into its keys
+func splitJSONPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+//lookupJSONPath appends the values found at path within value to found.
+//Arrays along the path are searched element by element. If flatten is
+//true, the elements of an array found at the end of the path are appended
+//rather than the array itself.
+func lookupJSONPath(value interface{}, path []string, flatten bool, found []interface{}) []interface{} {
+	if len(path) == 0 {
+		if array, ok := value.([]interface{}); ok && flatten {
+			for _, element := range array {
+				found = lookupJSONPath(element, nil, flatten, found)
+			}
+			return found
+		}
+		return append(found, value)
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, element := range v {
+			found = lookupJSONPath(element, path, flatten, found)
+		}
+	case map[string]interface{}:
+		if child, ok := v[path[0]]; ok {
+			found = lookupJSONPath(child, path[1:], flatten, found)
+		}
+	}
+	return found
+}
+
+//normalizeJSONValue converts the json.Numbers held by a value into int64s,
+//or float64s if they are not integers
+func normalizeJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, element := range v {
+			normalized[i] = normalizeJSONValue(element)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			normalized[key] = normalizeJSONValue(element)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+//containsType returns true if types holds entryType
+func containsType(types []list.BlacklistedEntryType, entryType list.BlacklistedEntryType) bool {
+	for _, t := range types {
+		if t == entryType {
+			return true
+		}
+	}
+	return false
+}
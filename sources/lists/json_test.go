@@ -0,0 +1,222 @@
+package lists
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestJSONListBL(t *testing.T) {
+	data := `{
+		"meta": {"generated": "today", "skipped": [1, {"a": [2, 3]}]},
+		"data": [
+			{"ip": "10.10.10.10", "domain": "evil.com", "score": 90,
+			 "malware": {"family": "Emotet"}, "tags": ["c2", "botnet"]},
+			{"ip": ["10.10.10.11", "10.10.10.12"], "score": 12.5,
+			 "observables": [{"domain": "bad.com"}, {"domain": "worse.com"}]}
+		]
+	}`
+	jsonList, err := NewJSONListFromSource("json", 86400, JSONOptions{
+		ItemsPath: "data",
+		Indexes: []JSONIndex{
+			{Type: list.BlacklistedIPType, Path: "ip"},
+			{Type: list.BlacklistedHostnameType, Path: "domain"},
+			{Type: list.BlacklistedHostnameType, Path: "observables.domain"},
+		},
+		Fields: []JSONField{
+			{Path: "score"},
+			{Path: "malware.family", Key: "family"},
+			{Path: "tags"},
+		},
+	}, stringSource(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := jsonList.GetMetadata()
+	if !reflect.DeepEqual(meta.Types, []list.BlacklistedEntryType{list.BlacklistedIPType, list.BlacklistedHostnameType}) {
+		t.Errorf("unexpected types %v", meta.Types)
+	}
+
+	b, reports := updateTestList(t, jsonList, nil)
+	if len(reports) != 1 || reports[0].Added != 6 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	ips := b.CheckEntries(list.BlacklistedIPType, "10.10.10.10", "10.10.10.12")
+	if len(ips["10.10.10.12"]) != 1 || ips["10.10.10.12"][0].ExtraData["score"] != 12.5 {
+		t.Errorf("unexpected results for 10.10.10.12 %+v", ips["10.10.10.12"])
+	}
+	if len(ips["10.10.10.10"]) != 1 {
+		t.Fatal("10.10.10.10 was not found")
+	}
+	extra := ips["10.10.10.10"][0].ExtraData
+	if extra["score"] != int64(90) || extra["family"] != "Emotet" ||
+		!reflect.DeepEqual(extra["tags"], []interface{}{"c2", "botnet"}) {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+
+	hosts := b.CheckEntries(list.BlacklistedHostnameType, "evil.com", "worse.com")
+	if len(hosts["evil.com"]) != 1 || len(hosts["worse.com"]) != 1 {
+		t.Errorf("unexpected hostname results %+v", hosts)
+	}
+}
+
+func TestJSONLinesListBL(t *testing.T) {
+	data := `{"indicator": "10.10.10.10", "port": 443}
+{"indicator": {"not": "an index"}}
+
+{"indicator": 167772171}
+{"other": "10.10.10.12"}
+`
+	jsonList, err := NewJSONListFromSource("jsonl", 86400, JSONOptions{
+		Indexes: []JSONIndex{{Type: list.BlacklistedIPType, Path: "indicator"}},
+		Fields:  []JSONField{{Path: "port"}},
+	}, stringSource(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	b, reports := updateTestList(t, jsonList, &errs)
+	if len(reports) != 1 || reports[0].Skipped != 1 || reports[0].Rejected != 1 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	//the object is reported and the number fails validation as an ip
+	var blErr *list.BlacklistError
+	if len(errs) != 2 || !errors.As(errs[0], &blErr) || blErr.Category != list.CategoryRecord {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	ip := "10.10.10.10"
+	results := b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+	if len(results) != 1 || results[0].ExtraData["port"] != int64(443) {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestJSONListStreams(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		itemsPath string
+		start     string
+		separator string
+		end       string
+	}{
+		{"lines", "", "", "\n", "\n"},
+		{"array", "", "[", ",", "]"},
+		{"items", "data", `{"data": [`, ",", "]}"},
+	} {
+		reader, writer := io.Pipe()
+		jsonList, err := NewJSONListFromSource("stream", 0, JSONOptions{
+			ItemsPath: c.itemsPath,
+			Indexes:   []JSONIndex{{Type: list.BlacklistedIPType, Path: "ip"}},
+		}, func(context.Context, *list.Metadata) (io.ReadCloser, error) {
+			return reader, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entryMap := list.NewBlacklistedEntryMap(list.BlacklistedIPType)
+		errorsOut := make(chan error, 10)
+		go jsonList.FetchData(context.Background(), entryMap, errorsOut)
+
+		//the first entry must be sent before the rest of the data is written
+		go writer.Write([]byte(c.start + `{"ip": "10.10.10.10"}` + c.separator))
+		select {
+		case entry := <-entryMap[list.BlacklistedIPType]:
+			if entry.Index != "10.10.10.10" {
+				t.Errorf("%s: unexpected entry %+v", c.name, entry)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the first document was not streamed", c.name)
+		}
+
+		go func() {
+			writer.Write([]byte(`{"ip": "10.10.10.11"}` + c.end))
+			writer.Close()
+		}()
+		var indexes []string
+		for entry := range entryMap[list.BlacklistedIPType] {
+			indexes = append(indexes, entry.Index)
+		}
+		close(errorsOut)
+		for err := range errorsOut {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(indexes, []string{"10.10.10.11"}) {
+			t.Errorf("%s: unexpected indexes %v", c.name, indexes)
+		}
+	}
+}
+
+func TestJSONWebListStreams(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//the server ignores If-None-Match, but sends the same etag
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, `[{"ip": "10.10.10.10"},`)
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, `{"ip": "10.10.10.11"}]`)
+	}))
+	defer server.Close()
+
+	jsonList, err := NewJSONWebList("web", 0, JSONOptions{
+		Indexes: []JSONIndex{{Type: list.BlacklistedIPType, Path: "ip"}},
+	}, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryMap := list.NewBlacklistedEntryMap(list.BlacklistedIPType)
+	errorsOut := make(chan error, 10)
+	go jsonList.FetchData(context.Background(), entryMap, errorsOut)
+
+	//the first entry must be sent before the rest of the data is downloaded
+	select {
+	case entry := <-entryMap[list.BlacklistedIPType]:
+		if entry.Index != "10.10.10.10" {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first document was not streamed")
+	}
+	close(release)
+	for range entryMap[list.BlacklistedIPType] {
+	}
+	meta := jsonList.GetMetadata()
+	if meta.ETag != `"v1"` || meta.ContentHash == "" {
+		t.Errorf("validators were not recorded: %+v", meta)
+	}
+
+	//the same etag means the list is unchanged
+	entryMap = list.NewBlacklistedEntryMap(list.BlacklistedIPType)
+	jsonList.FetchData(context.Background(), entryMap, errorsOut)
+	close(errorsOut)
+	var errs []error
+	for err := range errorsOut {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], list.ErrNotModified) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestJSONOptions(t *testing.T) {
+	for _, opts := range []JSONOptions{
+		{},
+		{Indexes: []JSONIndex{{Type: "email", Path: "email"}}},
+	} {
+		_, err := NewJSONListFromSource("json", 0, opts, stringSource(""))
+		if err == nil {
+			t.Errorf("invalid options %+v were accepted", opts)
+		}
+	}
+}
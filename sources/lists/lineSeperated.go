@@ -2,29 +2,64 @@ package lists
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"io"
 
 	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
 )
 
 type lineSeparatedList struct {
 	meta       list.Metadata
-	dataSource func() (io.ReadCloser, error)
+	dataSource DataSource
 }
 
 //NewLineSeparatedList returns a new lineSeparatedList object
 func NewLineSeparatedList(entryType list.BlacklistedEntryType, name string,
 	cacheTime int64, dataFactory func() (io.ReadCloser, error)) list.List {
+	return NewLineSeparatedListContext(entryType, name, cacheTime,
+		func(context.Context) (io.ReadCloser, error) {
+			return dataFactory()
+		},
+	)
+}
+
+//NewLineSeparatedListContext returns a new lineSeparatedList object whose
+//dataFactory receives the context passed to FetchData. The context should
+//be used to cancel any in-flight requests made by the dataFactory.
+func NewLineSeparatedListContext(entryType list.BlacklistedEntryType, name string,
+	cacheTime int64, dataFactory func(context.Context) (io.ReadCloser, error)) list.List {
+	return NewLineSeparatedListFromSource(entryType, name, cacheTime,
+		func(ctx context.Context, _ *list.Metadata) (io.ReadCloser, error) {
+			return dataFactory(ctx)
+		},
+	)
+}
+
+//NewLineSeparatedListFromSource returns a new lineSeparatedList object
+//which reads its data from the given DataSource
+func NewLineSeparatedListFromSource(entryType list.BlacklistedEntryType, name string,
+	cacheTime int64, source DataSource) list.List {
 	return &lineSeparatedList{
 		meta: list.Metadata{
 			Types:     []list.BlacklistedEntryType{entryType},
 			Name:      name,
 			CacheTime: cacheTime,
 		},
-		dataSource: dataFactory,
+		dataSource: source,
 	}
 }
 
+//NewLineSeparatedWebList returns a new lineSeparatedList object which
+//downloads its data from the given url. util.DefaultFetcher is used
+//if fetcher is nil. The list is not rewritten if the server reports it
+//is unchanged, or if the downloaded data is unchanged.
+func NewLineSeparatedWebList(entryType list.BlacklistedEntryType, name string,
+	cacheTime int64, url string, fetcher *util.Fetcher) list.List {
+	return NewLineSeparatedListFromSource(entryType, name, cacheTime, NewWebSource(url, fetcher))
+}
+
 //GetMetadata returns the Metadata associated with this blacklist
 func (m *lineSeparatedList) GetMetadata() list.Metadata {
 	return m.meta
@@ -38,21 +73,21 @@ func (m *lineSeparatedList) SetMetadata(meta list.Metadata) {
 //FetchData fetches the BlacklistedEntries associated with this list.
 //This function must run the fetch in the background and immediately
 //return a map of channels to read from.
-func (m *lineSeparatedList) FetchData(entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+func (m *lineSeparatedList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
 	entryType := m.GetMetadata().Types[0]
 	defer close(entryMap[entryType])
-	reader, err := m.dataSource()
-	if err != nil {
+	reader, err := m.dataSource(ctx, &m.meta)
+	if errors.Is(err, list.ErrNotModified) {
 		errorsOut <- err
 		return
 	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, m.meta.Name, entryType, "", err)
+		return
+	}
 	defer reader.Close()
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		if scanner.Err() != nil {
-			errorsOut <- scanner.Err()
-			return
-		}
 		line := scanner.Text()
 
 		//skip empty lines
@@ -64,6 +99,12 @@ func (m *lineSeparatedList) FetchData(entryMap list.BlacklistedEntryMap, errorsO
 			continue
 		}
 
-		entryMap[entryType] <- list.NewBlacklistedEntry(line, m)
+		if !list.SendEntry(ctx, entryMap[entryType], list.NewBlacklistedEntry(line, m)) {
+			errorsOut <- list.WrapError(list.CategoryFetch, m.meta.Name, entryType, "", ctx.Err())
+			return
+		}
+	}
+	if scanner.Err() != nil {
+		errorsOut <- list.WrapError(list.CategoryParse, m.meta.Name, entryType, "", scanner.Err())
 	}
 }
@@ -5,9 +5,6 @@ import (
 	"io"
 	"testing"
 
-	"github.com/activecm/mgosec"
-	blacklist "github.com/activecm/rita-bl"
-	"github.com/activecm/rita-bl/database"
 	"github.com/activecm/rita-bl/list"
 )
 
@@ -16,15 +13,6 @@ type nopCloser struct{ io.Reader }
 func (nopCloser) Close() error { return nil }
 
 func TestCustomBL(t *testing.T) {
-	db, err := database.NewMongoDB("localhost:27017", mgosec.None, "rita-blacklist-TEST-Custom")
-	if err != nil {
-		t.FailNow()
-	}
-	b := blacklist.NewBlacklist(db, func(err error) { panic(err) })
-
-	//clear the db
-	b.SetLists()
-	b.Update()
 	getData := func() (io.ReadCloser, error) {
 		buf := new(bytes.Buffer)
 		buf.WriteString(`
@@ -39,8 +27,7 @@ func TestCustomBL(t *testing.T) {
 
 	//get the data
 	customBL := NewLineSeparatedList(list.BlacklistedIPType, "test", 86400, getData)
-	b.SetLists(customBL)
-	b.Update()
+	b, _ := updateTestList(t, customBL, nil)
 
 	blIP := "10.10.10.10"
 	if len(b.CheckEntries(list.BlacklistedIPType, blIP)[blIP]) < 1 {
@@ -0,0 +1,331 @@
+package lists
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//mispTypes are the BlacklistedEntryTypes produced by MISP lists
+var mispTypes = []list.BlacklistedEntryType{
+	list.BlacklistedIPType,
+	list.BlacklistedCIDRType,
+	list.BlacklistedHostnameType,
+	list.BlacklistedURLType,
+}
+
+//mispThreatLevels maps MISP threat level ids onto their names
+var mispThreatLevels = map[string]string{
+	"1": "high",
+	"2": "medium",
+	"3": "low",
+	"4": "undefined",
+}
+
+type (
+	//mispList is a list with entries taken from the IDS flagged attributes
+	//of a MISP JSON export
+	mispList struct {
+		meta       list.Metadata
+		dataSource DataSource
+	}
+
+	//mispEvent holds the fields of a MISP event used by mispList
+	mispEvent struct {
+		ID            mispString      `json:"id"`
+		ThreatLevelID mispString      `json:"threat_level_id"`
+		Tags          []mispTag       `json:"Tag"`
+		Attributes    []mispAttribute `json:"Attribute"`
+		Objects       []struct {
+			Attributes []mispAttribute `json:"Attribute"`
+		} `json:"Object"`
+	}
+
+	//mispAttribute holds the fields of a MISP attribute used by mispList
+	mispAttribute struct {
+		ID      mispString `json:"id"`
+		EventID mispString `json:"event_id"`
+		Type    string     `json:"type"`
+		Value   string     `json:"value"`
+		ToIDS   bool       `json:"to_ids"`
+		Deleted bool       `json:"deleted"`
+		Tags    []mispTag  `json:"Tag"`
+		//Event is set on the attributes of attribute search results
+		Event *mispEvent `json:"Event"`
+	}
+
+	//mispTag holds the fields of a MISP tag used by mispList
+	mispTag struct {
+		Name string `json:"name"`
+	}
+
+	//mispString is a string which MISP may encode as a JSON string or number
+	mispString string
+)
+
+//UnmarshalJSON decodes a JSON string or number into a mispString
+func (m *mispString) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		err := json.Unmarshal(data, &value)
+		*m = mispString(value)
+		return err
+	}
+	var value json.Number
+	err := json.Unmarshal(data, &value)
+	*m = mispString(value)
+	return err
+}
+
+//NewMISPListFromSource returns a new mispList object which reads a MISP
+//JSON export from the given DataSource. Event dumps ({"Event": ...}, an
+//array of them, or the {"response": [...]} of an event search) and the
+//results of attribute searches ({"response": {"Attribute": [...]}}) are
+//supported.
+//
+//Attributes flagged for IDS produce entries. ip-src and ip-dst attributes
+//produce IP entries, or CIDR entries if they hold a prefix length.
+//domain and hostname attributes produce hostname entries, and url
+//attributes produce URL entries. The composite types ip-src|port,
+//ip-dst|port, hostname|port, and domain|ip are supported as well.
+//Entries hold the event_id, the tags of the event and attribute, and the
+//event's threat_level and threat_level_id in their ExtraData.
+func NewMISPListFromSource(name string, cacheTime int64, source DataSource) list.List {
+	return &mispList{
+		meta: list.Metadata{
+			Types:     mispTypes,
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		dataSource: source,
+	}
+}
+
+//NewMISPWebList returns a new mispList object which downloads a MISP JSON
+//export from the given url. The MISP API key may be supplied as the
+//Authorization header of the Fetcher. util.DefaultFetcher is used if
+//fetcher is nil.
+func NewMISPWebList(name string, cacheTime int64, url string, fetcher *util.Fetcher) list.List {
+	return NewMISPListFromSource(name, cacheTime, NewWebSource(url, fetcher))
+}
+
+//GetMetadata returns the Metadata associated with this blacklist
+func (m *mispList) GetMetadata() list.Metadata {
+	return m.meta
+}
+
+//SetMetadata sets the Metadata associated with this blacklist
+func (m *mispList) SetMetadata(meta list.Metadata) {
+	m.meta = meta
+}
+
+//FetchData fetches the BlacklistedEntries associated with this list.
+//Attributes of unsupported types are skipped. Malformed JSON stops
+//the fetch.
+func (m *mispList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	defer func() {
+		for _, entryType := range mispTypes {
+			close(entryMap[entryType])
+		}
+	}()
+	reader, err := m.dataSource(ctx, &m.meta)
+	if errors.Is(err, list.ErrNotModified) {
+		errorsOut <- err
+		return
+	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, m.meta.Name, "", "", err)
+		return
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	err = m.readValue(ctx, decoder, entryMap)
+	//an empty export holds no attributes
+	if errors.Is(err, errStopReading) || err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryParse, m.meta.Name, "", "", err)
+		return
+	}
+	if ctx.Err() != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, m.meta.Name, "", "", ctx.Err())
+	}
+}
+
+//readValue reads the next value from decoder, sending the entries of the
+//events and attribute lists it holds. Events are decoded one at a time,
+//as are the attributes of attribute search results.
+func (m *mispList) readValue(ctx context.Context, decoder *json.Decoder,
+	entryMap list.BlacklistedEntryMap) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('['):
+		for decoder.More() {
+			err = m.readValue(ctx, decoder, entryMap)
+			if err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			switch key {
+			case "Event":
+				var event mispEvent
+				err = decoder.Decode(&event)
+				if err == nil && !m.sendEvent(ctx, event, entryMap) {
+					err = errStopReading
+				}
+			case "Attribute":
+				err = readJSONArray(decoder, func(decoder *json.Decoder) (bool, error) {
+					var attribute mispAttribute
+					err := decoder.Decode(&attribute)
+					if err != nil {
+						return false, err
+					}
+					return m.sendAttribute(ctx, attribute, attribute.Event, entryMap), nil
+				})
+			case "response":
+				err = m.readValue(ctx, decoder, entryMap)
+			default:
+				err = skipJSONValue(decoder)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		//scalars hold no attributes
+		return nil
+	}
+	//consume the closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+//sendEvent sends the entries of the attributes held by an event and its
+//objects. Returns false if ctx was cancelled.
+func (m *mispList) sendEvent(ctx context.Context, event mispEvent, entryMap list.BlacklistedEntryMap) bool {
+	for _, attribute := range event.Attributes {
+		if !m.sendAttribute(ctx, attribute, &event, entryMap) {
+			return false
+		}
+	}
+	for _, object := range event.Objects {
+		for _, attribute := range object.Attributes {
+			if !m.sendAttribute(ctx, attribute, &event, entryMap) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//sendAttribute sends the entries of an IDS flagged attribute. event is
+//the event holding the attribute and may be nil.
+//Returns false if ctx was cancelled.
+func (m *mispList) sendAttribute(ctx context.Context, attribute mispAttribute,
+	event *mispEvent, entryMap list.BlacklistedEntryMap) bool {
+	if !attribute.ToIDS || attribute.Deleted {
+		return true
+	}
+	indexes := mispAttributeIndexes(attribute.Type, attribute.Value)
+	if len(indexes) == 0 {
+		return true
+	}
+
+	extraData := make(map[string]interface{})
+	eventID := string(attribute.EventID)
+	var tags []string
+	if event != nil {
+		if eventID == "" {
+			eventID = string(event.ID)
+		}
+		if name, ok := mispThreatLevels[string(event.ThreatLevelID)]; ok {
+			extraData["threat_level"] = name
+			extraData["threat_level_id"], _ = strconv.ParseInt(string(event.ThreatLevelID), 10, 64)
+		}
+		tags = appendMISPTags(tags, event.Tags)
+	}
+	tags = appendMISPTags(tags, attribute.Tags)
+	if eventID != "" {
+		extraData["event_id"] = eventID
+	}
+	if len(tags) > 0 {
+		extraData["tags"] = tags
+	}
+
+	for _, index := range indexes {
+		entry := list.NewBlacklistedEntry(index.value, m)
+		for key, value := range extraData {
+			entry.ExtraData[key] = value
+		}
+		if !list.SendEntry(ctx, entryMap[index.entryType], entry) {
+			return false
+		}
+	}
+	return true
+}
+
+//appendMISPTags appends the names of tags which aren't held by names
+func appendMISPTags(names []string, tags []mispTag) []string {
+	for _, tag := range tags {
+		if tag.Name != "" && !util.Contains(names, tag.Name) {
+			names = append(names, tag.Name)
+		}
+	}
+	return names
+}
+
+//mispAttributeIndexes returns the indexes held by an attribute of the
+//given MISP type. Returns nil for unsupported types.
+func mispAttributeIndexes(attributeType, value string) []typedIndex {
+	parts := strings.Split(value, "|")
+	switch attributeType {
+	case "ip-src", "ip-dst":
+		return []typedIndex{mispAddressIndex(value)}
+	case "ip-src|port", "ip-dst|port":
+		return []typedIndex{mispAddressIndex(parts[0])}
+	case "domain", "hostname":
+		return []typedIndex{{list.BlacklistedHostnameType, value}}
+	case "hostname|port":
+		return []typedIndex{{list.BlacklistedHostnameType, parts[0]}}
+	case "domain|ip":
+		if len(parts) != 2 {
+			return nil
+		}
+		return []typedIndex{{list.BlacklistedHostnameType, parts[0]}, mispAddressIndex(parts[1])}
+	case "url":
+		return []typedIndex{{list.BlacklistedURLType, value}}
+	default:
+		return nil
+	}
+}
+
+//mispAddressIndex returns an IP index, or a CIDR index if address holds
+//a prefix length
+func mispAddressIndex(address string) typedIndex {
+	if strings.Contains(address, "/") {
+		return typedIndex{list.BlacklistedCIDRType, address}
+	}
+	return typedIndex{list.BlacklistedIPType, address}
+}
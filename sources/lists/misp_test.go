@@ -0,0 +1,101 @@
+package lists
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+func TestMISPEventListBL(t *testing.T) {
+	data := `{"response": [
+		{"Event": {
+			"id": "12",
+			"info": "Emotet campaign",
+			"threat_level_id": "1",
+			"Tag": [{"name": "tlp:white"}, {"name": "misp-galaxy:malpedia=\"Emotet\""}],
+			"Attribute": [
+				{"id": "1", "event_id": "12", "type": "ip-dst", "value": "10.10.10.10", "to_ids": true,
+				 "Tag": [{"name": "c2"}, {"name": "tlp:white"}]},
+				{"id": "2", "event_id": "12", "type": "ip-src", "value": "192.168.0.0/24", "to_ids": true},
+				{"id": "3", "event_id": "12", "type": "ip-dst", "value": "10.10.10.11", "to_ids": false},
+				{"id": "4", "event_id": "12", "type": "ip-dst", "value": "10.10.10.12", "to_ids": true, "deleted": true},
+				{"id": "5", "event_id": "12", "type": "md5", "value": "d41d8cd98f00b204e9800998ecf8427e", "to_ids": true},
+				{"id": "6", "event_id": "12", "type": "domain|ip", "value": "evil.com|10.10.10.13", "to_ids": true}
+			],
+			"Object": [{"name": "url", "Attribute": [
+				{"id": "7", "event_id": "12", "type": "url", "value": "http://evil.com/gate.php", "to_ids": true},
+				{"id": "8", "event_id": "12", "type": "hostname", "value": "cdn.evil.com", "to_ids": true}
+			]}]
+		}},
+		{"Event": {"id": 13, "threat_level_id": 3, "Attribute": [
+			{"id": "9", "type": "ip-dst|port", "value": "10.10.10.14|443", "to_ids": true}
+		]}}
+	]}`
+	mispList := NewMISPListFromSource("misp", 86400, stringSource(data))
+	b, reports := updateTestList(t, mispList, nil)
+	if len(reports) != 1 || reports[0].Added != 7 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	ips := b.CheckEntries(list.BlacklistedIPType, "10.10.10.10", "192.168.0.7", "10.10.10.11",
+		"10.10.10.12", "10.10.10.13", "10.10.10.14")
+	for _, ip := range []string{"10.10.10.10", "192.168.0.7", "10.10.10.13", "10.10.10.14"} {
+		if len(ips[ip]) != 1 {
+			t.Errorf("%s was not found", ip)
+		}
+	}
+	for _, ip := range []string{"10.10.10.11", "10.10.10.12"} {
+		if len(ips[ip]) != 0 {
+			t.Errorf("%s was found", ip)
+		}
+	}
+	extra := ips["10.10.10.10"][0].ExtraData
+	if extra["event_id"] != "12" || extra["threat_level"] != "high" || extra["threat_level_id"] != int64(1) ||
+		!reflect.DeepEqual(extra["tags"], []string{"tlp:white", "misp-galaxy:malpedia=\"Emotet\"", "c2"}) {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+	if len(ips["10.10.10.14"]) == 1 {
+		extra = ips["10.10.10.14"][0].ExtraData
+		if extra["event_id"] != "13" || extra["threat_level"] != "low" {
+			t.Errorf("unexpected extra data %#v", extra)
+		}
+	}
+
+	hosts := b.CheckEntries(list.BlacklistedHostnameType, "evil.com", "cdn.evil.com")
+	if len(hosts["evil.com"]) != 1 || len(hosts["cdn.evil.com"]) != 1 {
+		t.Errorf("unexpected hostname results %+v", hosts)
+	}
+	gate := "http://evil.com/gate.php"
+	if len(b.CheckEntries(list.BlacklistedURLType, gate)[gate]) != 1 {
+		t.Errorf("%s was not found", gate)
+	}
+}
+
+func TestMISPAttributeListBL(t *testing.T) {
+	data := `{"response": {"Attribute": [
+		{"id": "1", "event_id": "12", "type": "domain", "value": "evil.com", "to_ids": true,
+		 "Event": {"id": "12", "info": "Emotet campaign", "threat_level_id": "2"},
+		 "Tag": [{"name": "tlp:amber"}]},
+		{"id": "2", "event_id": "14", "type": "ip-dst", "value": "10.10.10.10", "to_ids": true}
+	]}}`
+	mispList := NewMISPListFromSource("misp", 86400, stringSource(data))
+	b, _ := updateTestList(t, mispList, nil)
+
+	host := "evil.com"
+	results := b.CheckEntries(list.BlacklistedHostnameType, host)[host]
+	if len(results) != 1 {
+		t.Fatalf("%s was not found", host)
+	}
+	extra := results[0].ExtraData
+	if extra["event_id"] != "12" || extra["threat_level"] != "medium" ||
+		!reflect.DeepEqual(extra["tags"], []string{"tlp:amber"}) {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+
+	ip := "10.10.10.10"
+	results = b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+	if len(results) != 1 || results[0].ExtraData["event_id"] != "14" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
@@ -0,0 +1,196 @@
+package lists
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//DataSource produces the raw data of a list. meta points to the Metadata
+//of the list being fetched. A DataSource may return list.ErrNotModified if
+//the data is unchanged since the fetch recorded in meta, and it may update
+//the validators held in meta to record the new fetch. The context should
+//be used to cancel any in-flight requests.
+type DataSource func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error)
+
+//NewWebSource returns a DataSource which downloads the data at url with
+//the given Fetcher. util.DefaultFetcher is used if fetcher is nil.
+//The server is sent the ETag and LastModified validators held in the
+//list's Metadata, and list.ErrNotModified is returned if the server
+//reports the data is unchanged or the data has the same ContentHash.
+//The data is read into memory in order to hash it.
+func NewWebSource(url string, fetcher *util.Fetcher) DataSource {
+	if fetcher == nil {
+		fetcher = util.DefaultFetcher
+	}
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		validators := util.CacheValidators{ETag: meta.ETag, LastModified: meta.LastModified}
+		body, newValidators, err := fetcher.FetchConditional(ctx, url, validators)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		meta.ETag = newValidators.ETag
+		meta.LastModified = newValidators.LastModified
+		return checkContentHash(meta, data)
+	}
+}
+
+//NewFileSource returns a DataSource which reads the file at path.
+//list.ErrNotModified is returned if the file has the same ContentHash
+//as the last fetch. The file is read into memory in order to hash it.
+func NewFileSource(path string) DataSource {
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return checkContentHash(meta, data)
+	}
+}
+
+//NewStreamingWebSource returns a DataSource which downloads the data at
+//url with the given Fetcher like NewWebSource, but streams the data rather
+//than reading it into memory. list.ErrNotModified is only returned if the
+//server reports the data is unchanged or sends the same ETag as the last
+//fetch. The ContentHash is recorded once the data has been read to the end.
+func NewStreamingWebSource(url string, fetcher *util.Fetcher) DataSource {
+	if fetcher == nil {
+		fetcher = util.DefaultFetcher
+	}
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		validators := util.CacheValidators{ETag: meta.ETag, LastModified: meta.LastModified}
+		body, newValidators, err := fetcher.FetchConditional(ctx, url, validators)
+		if err != nil {
+			return nil, err
+		}
+		if meta.ETag != "" && newValidators.ETag == meta.ETag {
+			body.Close()
+			return nil, list.ErrNotModified
+		}
+		meta.ETag = newValidators.ETag
+		meta.LastModified = newValidators.LastModified
+		return newHashingReader(body, meta), nil
+	}
+}
+
+//NewStreamingFileSource returns a DataSource which streams the file at
+//path rather than reading it into memory. The file is read on every fetch.
+//The ContentHash is recorded once the file has been read to the end.
+func NewStreamingFileSource(path string) DataSource {
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return newHashingReader(file, meta), nil
+	}
+}
+
+//NewGzipSource returns a DataSource which decompresses the gzip
+//data produced by source
+func NewGzipSource(source DataSource) DataSource {
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		compressed, err := source(ctx, meta)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := gzip.NewReader(compressed)
+		if err != nil {
+			compressed.Close()
+			return nil, err
+		}
+		return readCloser{Reader: reader, closers: []io.Closer{reader, compressed}}, nil
+	}
+}
+
+//NewZipSource returns a DataSource which extracts the first file of the
+//zip archive produced by source. The archive is read into memory.
+func NewZipSource(source DataSource) DataSource {
+	return func(ctx context.Context, meta *list.Metadata) (io.ReadCloser, error) {
+		compressed, err := source(ctx, meta)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(compressed)
+		compressed.Close()
+		if err != nil {
+			return nil, err
+		}
+		zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		if len(zipReader.File) == 0 {
+			return nil, errors.New("zip archive is empty")
+		}
+		return zipReader.File[0].Open()
+	}
+}
+
+//checkContentHash returns list.ErrNotModified if the hash of data matches
+//the ContentHash held in meta. Otherwise, the new hash is recorded in meta
+//and a reader over data is returned.
+func checkContentHash(meta *list.Metadata, data []byte) (io.ReadCloser, error) {
+	hash := sha256.Sum256(data)
+	contentHash := hex.EncodeToString(hash[:])
+	if contentHash == meta.ContentHash {
+		return nil, list.ErrNotModified
+	}
+	meta.ContentHash = contentHash
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+//hashingReader hashes the data read through it and records the hash as
+//the ContentHash of meta once the end of the data is reached
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	meta *list.Metadata
+}
+
+//newHashingReader returns a hashingReader which reads from reader
+func newHashingReader(reader io.ReadCloser, meta *list.Metadata) *hashingReader {
+	return &hashingReader{ReadCloser: reader, hash: sha256.New(), meta: meta}
+}
+
+//Read reads from the underlying reader, adding the data to the hash
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF {
+		h.meta.ContentHash = hex.EncodeToString(h.hash.Sum(nil))
+	}
+	return n, err
+}
+
+//readCloser closes each of its closers in order when it is closed
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+//Close closes the underlying readers
+func (r readCloser) Close() error {
+	var firstErr error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
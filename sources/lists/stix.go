@@ -0,0 +1,434 @@
+package lists
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+	"github.com/activecm/rita-bl/sources/lists/util"
+)
+
+//taxiiMediaType is the media type of TAXII 2.1 responses
+const taxiiMediaType = "application/taxii+json;version=2.1"
+
+//stixTypes are the BlacklistedEntryTypes produced by STIX lists
+var stixTypes = []list.BlacklistedEntryType{
+	list.BlacklistedIPType,
+	list.BlacklistedCIDRType,
+	list.BlacklistedHostnameType,
+	list.BlacklistedURLType,
+}
+
+type (
+	//stixList is a list with entries taken from the patterns of STIX 2.1
+	//indicators. The indicators are read from a bundle held by a
+	//DataSource, or from the objects of a TAXII 2.1 collection.
+	stixList struct {
+		meta       list.Metadata
+		dataSource DataSource
+		collection string
+		fetcher    *util.Fetcher
+	}
+
+	//stixIndicator holds the fields of a STIX indicator used by stixList
+	stixIndicator struct {
+		Type        string   `json:"type"`
+		ID          string   `json:"id"`
+		Pattern     string   `json:"pattern"`
+		PatternType string   `json:"pattern_type"`
+		Labels      []string `json:"labels"`
+		Confidence  *int64   `json:"confidence"`
+		ValidFrom   string   `json:"valid_from"`
+		ValidUntil  string   `json:"valid_until"`
+		Revoked     bool     `json:"revoked"`
+	}
+
+	//typedIndex is an index along with its type
+	typedIndex struct {
+		entryType list.BlacklistedEntryType
+		value     string
+	}
+)
+
+//NewSTIXListFromSource returns a new stixList object which reads a STIX 2.1
+//bundle from the given DataSource. Indicators with patterns comparing the
+//values of ipv4-addr, ipv6-addr, domain-name, and url objects produce
+//entries holding the indicator's id, labels, confidence, valid_from, and
+//valid_until in their ExtraData. Times are stored as unix timestamps.
+//
+//Patterns which join comparisons with AND or FOLLOWEDBY are skipped, since
+//they can't be matched by a single index. Revoked and expired indicators
+//are skipped as well.
+func NewSTIXListFromSource(name string, cacheTime int64, source DataSource) list.List {
+	return &stixList{
+		meta: list.Metadata{
+			Types:     stixTypes,
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		dataSource: source,
+	}
+}
+
+//NewSTIXFileList returns a new stixList object which reads a STIX 2.1
+//bundle from the file at path
+func NewSTIXFileList(name string, cacheTime int64, path string) list.List {
+	return NewSTIXListFromSource(name, cacheTime, NewFileSource(path))
+}
+
+//NewTAXIIList returns a new stixList object which reads the indicators held
+//by a TAXII 2.1 collection. collectionURL is the URL of the collection, e.g.
+//https://example.com/api/collections/91a7b528-80eb-42ed-a74d-c6fbd5a26116/.
+//Credentials may be supplied through the Headers of the Fetcher.
+//util.DefaultFetcher is used if fetcher is nil.
+func NewTAXIIList(name string, cacheTime int64, collectionURL string, fetcher *util.Fetcher) list.List {
+	if fetcher == nil {
+		fetcher = util.DefaultFetcher
+	}
+	return &stixList{
+		meta: list.Metadata{
+			Types:     stixTypes,
+			Name:      name,
+			CacheTime: cacheTime,
+		},
+		collection: strings.TrimSuffix(collectionURL, "/") + "/",
+		fetcher:    fetcher,
+	}
+}
+
+//GetMetadata returns the Metadata associated with this blacklist
+func (s *stixList) GetMetadata() list.Metadata {
+	return s.meta
+}
+
+//SetMetadata sets the Metadata associated with this blacklist
+func (s *stixList) SetMetadata(meta list.Metadata) {
+	s.meta = meta
+}
+
+//FetchData fetches the BlacklistedEntries associated with this list.
+//Indicators with patterns or timestamps which can't be parsed are reported
+//as record errors and skipped. Malformed JSON stops the fetch.
+func (s *stixList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	defer func() {
+		for _, entryType := range stixTypes {
+			close(entryMap[entryType])
+		}
+	}()
+
+	handle := func(decoder *json.Decoder) (bool, error) {
+		var indicator stixIndicator
+		err := decoder.Decode(&indicator)
+		if err != nil {
+			return false, err
+		}
+		return s.sendIndicator(ctx, indicator, entryMap, errorsOut), nil
+	}
+
+	var err error
+	if s.collection != "" {
+		err = s.readCollection(ctx, handle)
+	} else {
+		err = s.readBundle(ctx, handle)
+	}
+	//list.ErrNotModified is sent as is, other errors are already wrapped
+	if err != nil {
+		errorsOut <- err
+		return
+	}
+	if ctx.Err() != nil {
+		errorsOut <- list.WrapError(list.CategoryFetch, s.meta.Name, "", "", ctx.Err())
+	}
+}
+
+//readBundle reads the objects of the bundle held by the list's DataSource
+func (s *stixList) readBundle(ctx context.Context, handle func(*json.Decoder) (bool, error)) error {
+	reader, err := s.dataSource(ctx, &s.meta)
+	if errors.Is(err, list.ErrNotModified) {
+		return err
+	}
+	if err != nil {
+		return list.WrapError(list.CategoryFetch, s.meta.Name, "", "", err)
+	}
+	defer reader.Close()
+
+	err = readJSONDocuments(bufio.NewReader(reader), "objects", handle)
+	if err != nil {
+		return list.WrapError(list.CategoryParse, s.meta.Name, "", "", err)
+	}
+	return nil
+}
+
+//readCollection reads the indicators of the list's TAXII collection,
+//requesting each page of objects in turn
+func (s *stixList) readCollection(ctx context.Context, handle func(*json.Decoder) (bool, error)) error {
+	headers := make(http.Header)
+	headers.Set("Accept", taxiiMediaType)
+	next := ""
+	for {
+		query := url.Values{"match[type]": {"indicator"}}
+		if next != "" {
+			query.Set("next", next)
+		}
+		body, err := s.fetcher.FetchWithHeaders(ctx, s.collection+"objects/?"+query.Encode(), headers)
+		if err != nil {
+			return list.WrapError(list.CategoryFetch, s.meta.Name, "", "", err)
+		}
+		more, nextPage, err := readTAXIIEnvelope(body, handle)
+		body.Close()
+		if errors.Is(err, errStopReading) {
+			return nil
+		}
+		if err != nil {
+			return list.WrapError(list.CategoryParse, s.meta.Name, "", "", err)
+		}
+		if !more || ctx.Err() != nil {
+			return nil
+		}
+		if nextPage == "" || nextPage == next {
+			return list.WrapError(list.CategoryParse, s.meta.Name, "", "",
+				errors.New("taxii server reported more objects without a new next value"))
+		}
+		next = nextPage
+	}
+}
+
+//readTAXIIEnvelope passes the objects of a TAXII envelope to handle and
+//returns the envelope's more and next values
+func readTAXIIEnvelope(reader io.Reader, handle func(*json.Decoder) (bool, error)) (bool, string, error) {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return false, "", err
+	}
+	if token != json.Delim('{') {
+		return false, "", errors.New("expected a taxii envelope")
+	}
+
+	var more bool
+	var next string
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return false, "", err
+		}
+		switch key {
+		case "more":
+			err = decoder.Decode(&more)
+		case "next":
+			err = decoder.Decode(&next)
+		case "objects":
+			err = readJSONArray(decoder, handle)
+		default:
+			err = skipJSONValue(decoder)
+		}
+		if err != nil {
+			return false, "", err
+		}
+	}
+	return more, next, nil
+}
+
+//errStopReading is returned by readJSONArray when handle returns false
+var errStopReading = errors.New("stopped reading the array")
+
+//readJSONArray reads an array from decoder, calling handle for each
+//element until it returns false
+func readJSONArray(decoder *json.Decoder, handle func(*json.Decoder) (bool, error)) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return errors.New("expected an array")
+	}
+	for decoder.More() {
+		ok, err := handle(decoder)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errStopReading
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+//sendIndicator sends an entry for each value compared against by an
+//indicator's pattern. Returns false if ctx was cancelled.
+func (s *stixList) sendIndicator(ctx context.Context, indicator stixIndicator,
+	entryMap list.BlacklistedEntryMap, errorsOut chan<- error) bool {
+	if indicator.Type != "indicator" || indicator.Revoked {
+		return true
+	}
+	if indicator.PatternType != "" && indicator.PatternType != "stix" {
+		return true
+	}
+
+	extraData := map[string]interface{}{"id": indicator.ID}
+	if len(indicator.Labels) > 0 {
+		extraData["labels"] = indicator.Labels
+	}
+	if indicator.Confidence != nil {
+		extraData["confidence"] = *indicator.Confidence
+	}
+	for key, value := range map[string]string{
+		"valid_from":  indicator.ValidFrom,
+		"valid_until": indicator.ValidUntil,
+	} {
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			errorsOut <- list.WrapError(list.CategoryRecord, s.meta.Name, "", "",
+				fmt.Errorf("indicator %s: invalid %s: %w", indicator.ID, key, err))
+			return true
+		}
+		extraData[key] = parsed.Unix()
+	}
+	if validUntil, ok := extraData["valid_until"].(int64); ok && validUntil <= time.Now().Unix() {
+		return true
+	}
+
+	observables, err := parseSTIXPattern(indicator.Pattern)
+	if err != nil {
+		errorsOut <- list.WrapError(list.CategoryRecord, s.meta.Name, "", "",
+			fmt.Errorf("indicator %s: %w", indicator.ID, err))
+		return true
+	}
+	for _, observable := range observables {
+		entry := list.NewBlacklistedEntry(observable.value, s)
+		for key, value := range extraData {
+			entry.ExtraData[key] = value
+		}
+		if !list.SendEntry(ctx, entryMap[observable.entryType], entry) {
+			return false
+		}
+	}
+	return true
+}
+
+//stixObjectPaths maps the object paths supported in STIX patterns to
+//the types of the values compared against them
+var stixObjectPaths = map[string]list.BlacklistedEntryType{
+	"ipv4-addr:value":   list.BlacklistedIPType,
+	"ipv6-addr:value":   list.BlacklistedIPType,
+	"domain-name:value": list.BlacklistedHostnameType,
+	"url:value":         list.BlacklistedURLType,
+}
+
+//parseSTIXPattern returns the values compared against with = or IN by
+//the comparisons of a STIX pattern which use a supported object path.
+//Addresses holding a prefix length are returned as CIDR ranges. Patterns
+//joining comparisons with AND or FOLLOWEDBY return no values, and negated
+//comparisons are ignored.
+func parseSTIXPattern(pattern string) ([]typedIndex, error) {
+	tokens, err := tokenizeSTIXPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		if token == "AND" || token == "FOLLOWEDBY" {
+			return nil, nil
+		}
+	}
+
+	var observables []typedIndex
+	add := func(entryType list.BlacklistedEntryType, literal string) {
+		value := unquoteSTIXString(literal)
+		if entryType == list.BlacklistedIPType && strings.Contains(value, "/") {
+			entryType = list.BlacklistedCIDRType
+		}
+		observables = append(observables, typedIndex{entryType: entryType, value: value})
+	}
+	for i := 0; i < len(tokens); i++ {
+		entryType, ok := stixObjectPaths[tokens[i]]
+		if !ok || i+1 >= len(tokens) {
+			continue
+		}
+		operator := tokens[i+1]
+		switch {
+		case operator == "=" && i+2 < len(tokens) && isSTIXString(tokens[i+2]):
+			add(entryType, tokens[i+2])
+			i += 2
+		case operator == "IN" && i+2 < len(tokens) && tokens[i+2] == "(":
+			for i += 3; i < len(tokens) && tokens[i] != ")"; i++ {
+				if isSTIXString(tokens[i]) {
+					add(entryType, tokens[i])
+				}
+			}
+		}
+	}
+	return observables, nil
+}
+
+//tokenizeSTIXPattern splits a STIX pattern into brackets, parentheses,
+//commas, comparison operators, quoted strings, and words such as object
+//paths and keywords. Quoted strings keep their quotes.
+func tokenizeSTIXPattern(pattern string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(pattern); {
+		switch c := pattern[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case strings.IndexByte("[](),", c) >= 0:
+			tokens = append(tokens, pattern[i:i+1])
+			i++
+		case strings.IndexByte("=!<>", c) >= 0:
+			end := i + 1
+			if end < len(pattern) && pattern[end] == '=' {
+				end++
+			}
+			tokens = append(tokens, pattern[i:end])
+			i = end
+		case c == '\'':
+			end := i + 1
+			for ; end < len(pattern) && pattern[end] != '\''; end++ {
+				if pattern[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(pattern) {
+				return nil, fmt.Errorf("unterminated string in pattern %q", pattern)
+			}
+			tokens = append(tokens, pattern[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(pattern) && strings.IndexByte(" \t\r\n[](),=!<>'", pattern[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, pattern[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+//isSTIXString returns true if a token is a quoted string
+func isSTIXString(token string) bool {
+	return len(token) >= 2 && token[0] == '\''
+}
+
+//unquoteSTIXString removes the quotes and escapes from a quoted string
+func unquoteSTIXString(token string) string {
+	token = token[1 : len(token)-1]
+	var builder strings.Builder
+	for i := 0; i < len(token); i++ {
+		if token[i] == '\\' && i+1 < len(token) {
+			i++
+		}
+		builder.WriteByte(token[i])
+	}
+	return builder.String()
+}
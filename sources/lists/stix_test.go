@@ -0,0 +1,208 @@
+package lists
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	blacklist "github.com/activecm/rita-bl"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+const stixBundle = `{
+	"type": "bundle",
+	"id": "bundle--5d0092c5-5f74-4287-9642-33f4c354e56d",
+	"objects": [
+		{"type": "malware", "id": "malware--1", "name": "Emotet", "is_family": true},
+		{
+			"type": "indicator",
+			"spec_version": "2.1",
+			"id": "indicator--1",
+			"pattern": "[ipv4-addr:value = '10.10.10.10' OR ipv4-addr:value = '192.168.0.0/24']",
+			"pattern_type": "stix",
+			"labels": ["malicious-activity", "c2"],
+			"confidence": 85,
+			"valid_from": "2021-01-02T03:04:05.000Z",
+			"valid_until": "2999-01-01T00:00:00Z"
+		},
+		{
+			"type": "indicator",
+			"id": "indicator--2",
+			"pattern": "[domain-name:value IN ('evil.com', 'worse.com')] OR [url:value = 'http://evil.com/gate.php']",
+			"pattern_type": "stix",
+			"valid_from": "2021-01-02T03:04:05Z"
+		},
+		{
+			"type": "indicator",
+			"id": "indicator--3",
+			"pattern": "[ipv6-addr:value = '2001:db8::1']",
+			"pattern_type": "stix",
+			"valid_from": "2021-01-02T03:04:05Z"
+		},
+		{
+			"type": "indicator",
+			"id": "indicator--expired",
+			"pattern": "[ipv4-addr:value = '10.10.10.11']",
+			"pattern_type": "stix",
+			"valid_from": "2020-01-01T00:00:00Z",
+			"valid_until": "2020-02-01T00:00:00Z"
+		},
+		{
+			"type": "indicator",
+			"id": "indicator--revoked",
+			"pattern": "[ipv4-addr:value = '10.10.10.12']",
+			"pattern_type": "stix",
+			"valid_from": "2020-01-01T00:00:00Z",
+			"revoked": true
+		},
+		{
+			"type": "indicator",
+			"id": "indicator--snort",
+			"pattern": "alert tcp 10.10.10.13 any -> any any",
+			"pattern_type": "snort",
+			"valid_from": "2020-01-01T00:00:00Z"
+		}
+	]
+}`
+
+func TestSTIXListBL(t *testing.T) {
+	stixList := NewSTIXListFromSource("stix", 86400, stringSource(stixBundle))
+	b, reports := updateTestList(t, stixList, nil)
+	if len(reports) != 1 || reports[0].Added != 6 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	ips := b.CheckEntries(list.BlacklistedIPType, "10.10.10.10", "192.168.0.7", "2001:db8::1",
+		"10.10.10.11", "10.10.10.12", "10.10.10.13")
+	for _, ip := range []string{"10.10.10.10", "192.168.0.7", "2001:db8::1"} {
+		if len(ips[ip]) != 1 {
+			t.Errorf("%s was not found", ip)
+		}
+	}
+	for _, ip := range []string{"10.10.10.11", "10.10.10.12", "10.10.10.13"} {
+		if len(ips[ip]) != 0 {
+			t.Errorf("%s was found", ip)
+		}
+	}
+	extra := ips["10.10.10.10"][0].ExtraData
+	if extra["id"] != "indicator--1" || extra["confidence"] != int64(85) ||
+		extra["valid_from"] != int64(1609556645) || extra["valid_until"] != int64(32472144000) ||
+		!reflect.DeepEqual(extra["labels"], []string{"malicious-activity", "c2"}) {
+		t.Errorf("unexpected extra data %#v", extra)
+	}
+
+	hosts := b.CheckEntries(list.BlacklistedHostnameType, "evil.com", "worse.com")
+	if len(hosts["evil.com"]) != 1 || len(hosts["worse.com"]) != 1 {
+		t.Errorf("unexpected hostname results %+v", hosts)
+	}
+	gate := "http://evil.com/gate.php"
+	if len(b.CheckEntries(list.BlacklistedURLType, gate)[gate]) != 1 {
+		t.Errorf("%s was not found", gate)
+	}
+}
+
+func TestSTIXListBadIndicatorsBL(t *testing.T) {
+	bundle := `{"type": "bundle", "objects": [
+		{"type": "indicator", "id": "indicator--good", "pattern": "[ipv4-addr:value = '10.10.10.10']"},
+		{"type": "indicator", "id": "indicator--pattern", "pattern": "[ipv4-addr:value = '10.10.10.12]"},
+		{"type": "indicator", "id": "indicator--time", "pattern": "[ipv4-addr:value = '10.10.10.11']",
+			"valid_until": "tomorrow"}
+	]}`
+	db := database.NewMemoryDB()
+	var errs []error
+	b := newTestBlacklist(t, db, &errs, NewSTIXListFromSource("stix", 86400, stringSource(bundle)))
+	reports := b.Update()
+	if len(reports) != 1 || reports[0].Action != blacklist.ActionCreated ||
+		reports[0].Added != 1 || reports[0].Skipped != 2 || len(errs) != 2 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	//the bad indicators don't stop the list from being cached
+	metas, err := db.GetRegisteredLists(context.Background())
+	if err != nil || len(metas) != 1 || metas[0].LastUpdate == 0 {
+		t.Errorf("the list was not marked as updated: %+v", metas)
+	}
+	ip := "10.10.10.10"
+	if len(b.CheckEntries(list.BlacklistedIPType, ip)[ip]) != 1 {
+		t.Errorf("%s was not found", ip)
+	}
+}
+
+func TestTAXIIListBL(t *testing.T) {
+	pages := map[string]string{
+		"": `{"more": true, "next": "page2", "objects": [
+			{"type": "indicator", "id": "indicator--1", "pattern": "[ipv4-addr:value = '10.10.10.10']",
+			 "pattern_type": "stix", "valid_from": "2021-01-02T03:04:05Z"}
+		]}`,
+		"page2": `{"objects": [
+			{"type": "indicator", "id": "indicator--2", "pattern": "[domain-name:value = 'evil.com']",
+			 "pattern_type": "stix", "valid_from": "2021-01-02T03:04:05Z"}
+		], "more": false}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/collections/indicators/objects/" ||
+			r.Header.Get("Accept") != taxiiMediaType ||
+			r.URL.Query().Get("match[type]") != "indicator" {
+			http.NotFound(w, r)
+			return
+		}
+		page, ok := pages[r.URL.Query().Get("next")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", taxiiMediaType)
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	taxiiList := NewTAXIIList("taxii", 86400, server.URL+"/api/collections/indicators", nil)
+	b, _ := updateTestList(t, taxiiList, nil)
+
+	ip := "10.10.10.10"
+	results := b.CheckEntries(list.BlacklistedIPType, ip)[ip]
+	if len(results) != 1 || results[0].ExtraData["id"] != "indicator--1" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	host := "evil.com"
+	if len(b.CheckEntries(list.BlacklistedHostnameType, host)[host]) != 1 {
+		t.Errorf("%s from the second page was not found", host)
+	}
+}
+
+func TestParseSTIXPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern  string
+		expected []typedIndex
+	}{
+		{"[ipv4-addr:value = '1.2.3.4']", []typedIndex{{list.BlacklistedIPType, "1.2.3.4"}}},
+		{"[ipv4-addr:value='1.2.3.0/24']", []typedIndex{{list.BlacklistedCIDRType, "1.2.3.0/24"}}},
+		{"[url:value = 'http://a.com/it\\'s']", []typedIndex{{list.BlacklistedURLType, "http://a.com/it's"}}},
+		{"([domain-name:value = 'a.com'] OR [domain-name:value = 'b.com']) WITHIN 300 SECONDS",
+			[]typedIndex{{list.BlacklistedHostnameType, "a.com"}, {list.BlacklistedHostnameType, "b.com"}}},
+		{"[ipv4-addr:value = '1.2.3.4' AND ipv4-addr:value = '1.2.3.5']", nil},
+		{"[ipv4-addr:value = '1.2.3.4'] FOLLOWEDBY [domain-name:value = 'a.com']", nil},
+		{"[ipv4-addr:value NOT = '1.2.3.4']", nil},
+		{"[ipv4-addr:value != '1.2.3.4']", nil},
+		{"[file:name = 'ipv4-addr:value = \\'1.2.3.4\\'']", nil},
+		{"[network-traffic:dst_port = 443]", nil},
+	} {
+		observables, err := parseSTIXPattern(c.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", c.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(observables, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.pattern, c.expected, observables)
+		}
+	}
+
+	_, err := parseSTIXPattern("[ipv4-addr:value = '1.2.3.4]")
+	if err == nil {
+		t.Error("an unterminated string was accepted")
+	}
+}
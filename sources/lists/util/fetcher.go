@@ -0,0 +1,186 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/activecm/rita-bl/list"
+)
+
+//DefaultTimeout is the time limit placed on requests made by fetchers
+//which do not set a Timeout
+const DefaultTimeout = 5 * time.Minute
+
+//DefaultUserAgent is the User-Agent sent by fetchers which do not set one
+const DefaultUserAgent = "rita-bl"
+
+//DefaultFetcher is used by list sources which are not given a Fetcher
+var DefaultFetcher = &Fetcher{
+	client:    &http.Client{Timeout: DefaultTimeout},
+	userAgent: DefaultUserAgent,
+}
+
+//FetcherOptions configures the HTTP requests made by a Fetcher
+type FetcherOptions struct {
+	//Client is used to make requests if set. ProxyURL, TLSConfig, and
+	//Timeout are ignored when Client is set.
+	Client *http.Client
+	//ProxyURL is the proxy requests are sent through. Defaults to the
+	//proxy set by the HTTP_PROXY and HTTPS_PROXY environment variables.
+	ProxyURL string
+	//TLSConfig is used for HTTPS requests, e.g. to trust a custom CA
+	TLSConfig *tls.Config
+	//Timeout limits the time spent on a request, including reading the
+	//response body. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	//Headers are added to every request, e.g. an Authorization header
+	Headers http.Header
+	//UserAgent is sent with every request. Defaults to DefaultUserAgent.
+	UserAgent string
+}
+
+//Fetcher downloads the data for list sources over HTTP
+type Fetcher struct {
+	client    *http.Client
+	headers   http.Header
+	userAgent string
+}
+
+//HTTPStatusError is returned when a server responds with a status code
+//outside of the 2xx range
+type HTTPStatusError struct {
+	//URL is the URL which was requested
+	URL string
+	//StatusCode is the status code the server responded with
+	StatusCode int
+}
+
+//Error implements the error interface
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s fetching %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+//CacheValidators identify the version of a resource held by a client so
+//that the server can skip sending it again if it is unchanged
+type CacheValidators struct {
+	//ETag is the entity tag returned with the resource
+	ETag string
+	//LastModified is the Last-Modified header returned with the resource
+	LastModified string
+}
+
+//NewFetcher creates a new Fetcher with the given options
+func NewFetcher(opts FetcherOptions) (*Fetcher, error) {
+	client := opts.Client
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if opts.ProxyURL != "" {
+			proxyURL, err := url.Parse(opts.ProxyURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy url: %w", err)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		if opts.TLSConfig != nil {
+			transport.TLSClientConfig = opts.TLSConfig
+		}
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		client = &http.Client{Transport: transport, Timeout: timeout}
+	}
+
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return &Fetcher{
+		client:    client,
+		headers:   opts.Headers.Clone(),
+		userAgent: userAgent,
+	}, nil
+}
+
+//Fetch requests the given URL and returns the response body. Responses
+//with a status code outside of the 2xx range are rejected with an
+//HTTPStatusError. The request is abandoned if ctx is cancelled.
+func (f *Fetcher) Fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	body, _, err := f.FetchConditional(ctx, url, CacheValidators{})
+	return body, err
+}
+
+//FetchWithHeaders requests the given URL like Fetch, adding the given
+//headers to the request, e.g. an Accept header required by an API
+func (f *Fetcher) FetchWithHeaders(ctx context.Context, url string, headers http.Header) (io.ReadCloser, error) {
+	resp, err := f.do(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+//FetchConditional requests the given URL unless the server reports that
+//it is unchanged since the version identified by validators, in which case
+//list.ErrNotModified is returned. The validators of the new version are
+//returned along with the response body.
+func (f *Fetcher) FetchConditional(ctx context.Context, url string,
+	validators CacheValidators) (io.ReadCloser, CacheValidators, error) {
+	headers := make(http.Header)
+	if validators.ETag != "" {
+		headers.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		headers.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := f.do(ctx, url, headers)
+	if err != nil {
+		return nil, CacheValidators{}, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, validators, list.ErrNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, CacheValidators{}, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	newValidators := CacheValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return resp.Body, newValidators, nil
+}
+
+//do sends a GET request for the given URL with the Fetcher's headers
+//followed by the given headers
+func (f *Fetcher) do(ctx context.Context, url string, headers http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range f.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	for key, values := range headers {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return f.client.Do(req)
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("User-Agent") != "test-agent" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "10.10.10.10\n")
+	}))
+	defer server.Close()
+
+	fetcher, err := NewFetcher(FetcherOptions{
+		Headers:   http.Header{"Authorization": {"Bearer token"}},
+		UserAgent: "test-agent",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := fetcher.Fetch(context.Background(), server.URL+"/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "10.10.10.10\n" {
+		t.Errorf("unexpected body %q", data)
+	}
+
+	//error pages are not returned as list data
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing")
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 HTTPStatusError, got %v", err)
+	}
+
+	if _, err = NewFetcher(FetcherOptions{ProxyURL: "://bad"}); err == nil {
+		t.Error("invalid proxy url was accepted")
+	}
+}
+
+func TestFetchZipped(t *testing.T) {
+	archive := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(archive)
+	file, _ := zipWriter.Create("list.txt")
+	io.WriteString(file, "evil.com\n")
+	zipWriter.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes())
+	}))
+	defer server.Close()
+
+	reader, err := ReadZippedFileFromWeb(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "evil.com\n" {
+		t.Errorf("unexpected file contents %q", data)
+	}
+}
@@ -3,21 +3,30 @@ package util
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
 	"io"
-	"net/http"
 )
 
 //ReadZippedFileFromWeb reads a .zip archive containing a single .
 //This format is common for blacklist distribution.
 func ReadZippedFileFromWeb(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+	return DefaultFetcher.FetchZipped(context.Background(), url)
+}
+
+//FetchZipped downloads a .zip archive and returns the first file inside it
+func (f *Fetcher) FetchZipped(ctx context.Context, url string) (io.ReadCloser, error) {
+	body, err := f.Fetch(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	//read the file into ram
 	buff := new(bytes.Buffer)
-	io.Copy(buff, resp.Body)
-	resp.Body.Close()
+	_, err = io.Copy(buff, body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
 
 	//extract the zip archive
 	buffer := buff.Bytes()
@@ -26,6 +35,9 @@ func ReadZippedFileFromWeb(url string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(zipReader.File) == 0 {
+		return nil, errors.New("zip archive is empty")
+	}
 
 	//open the file inside
 	fileHandle, err := zipReader.File[0].Open()
@@ -34,3 +46,13 @@ func ReadZippedFileFromWeb(url string) (io.ReadCloser, error) {
 	}
 	return fileHandle, nil
 }
+
+//Contains returns true if values holds value
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package mock
 
 import (
+	"context"
 	"encoding/binary"
 	"net"
 	"strings"
@@ -39,21 +40,29 @@ func (d *dummyList) SetMetadata(m list.Metadata) {
 //FetchData fetches the BlacklistedEntries associated with this list.
 //This function must run the fetch in the background and immediately
 //return a map of channels to read from.
-func (d *dummyList) FetchData(entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+func (d *dummyList) FetchData(ctx context.Context, entryMap list.BlacklistedEntryMap, errorsOut chan<- error) {
+	defer close(entryMap[list.BlacklistedIPType])
+	defer close(entryMap[list.BlacklistedHostnameType])
+
 	var i uint32
 	for i = 0; i < 100; i++ {
 		bs := make([]byte, 4)
 		binary.LittleEndian.PutUint32(bs, i)
 		ipAddr := net.IPv4(bs[0], bs[1], bs[2], bs[3]).String()
 		entry := list.NewBlacklistedEntry(ipAddr, d)
-		entryMap[list.BlacklistedIPType] <- entry
+		if !list.SendEntry(ctx, entryMap[list.BlacklistedIPType], entry) {
+			errorsOut <- ctx.Err()
+			return
+		}
 	}
-	close(entryMap[list.BlacklistedIPType])
 
 	for _, line := range strings.Split(hostnames, "\n") {
-		entryMap[list.BlacklistedHostnameType] <- list.NewBlacklistedEntry(line, d)
+		entry := list.NewBlacklistedEntry(line, d)
+		if !list.SendEntry(ctx, entryMap[list.BlacklistedHostnameType], entry) {
+			errorsOut <- ctx.Err()
+			return
+		}
 	}
-	close(entryMap[list.BlacklistedHostnameType])
 }
 
 const hostnames string = `163.com
@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/activecm/rita-bl/database"
+	bl "github.com/activecm/rita-bl/list"
+)
+
+//CacheOptions configures the cache created by NewCachedRPC
+type CacheOptions struct {
+	//TTL is how long results returned by the RPC are cached
+	TTL time.Duration
+	//NegativeTTL is how long indexes without a result are cached.
+	//Defaults to TTL.
+	NegativeTTL time.Duration
+	//MaxEntries is the maximum number of indexes held in memory. The least
+	//recently used indexes are evicted first. 0 means no limit.
+	MaxEntries int
+	//Store persists the cache through a database Handle, if set. Cached
+	//results are loaded on the first Check and new results are stored
+	//after each Check.
+	Store database.Handle
+	//Name is the name the cache is persisted under.
+	//Defaults to the RPC's entry type.
+	Name string
+	//ErrorHandler receives errors which occur while persisting the cache.
+	//These errors do not fail the Check. Errors are dropped if nil.
+	ErrorHandler func(error)
+}
+
+//cachedRPC wraps an RPC with a TTL and LRU bounded cache of its results
+type cachedRPC struct {
+	rpc     RPC
+	opts    CacheOptions
+	mutex   *sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	loaded  bool
+}
+
+//NewCachedRPC wraps an RPC with a cache holding both the results the RPC
+//returns and the indexes it has no results for. Only the indexes missing
+//from the cache, or whose cached results have expired, are sent to the
+//wrapped RPC.
+func NewCachedRPC(rpc RPC, opts CacheOptions) RPC {
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = opts.TTL
+	}
+	if opts.Name == "" {
+		opts.Name = string(rpc.GetType())
+	}
+	return &cachedRPC{
+		rpc:     rpc,
+		opts:    opts,
+		mutex:   new(sync.Mutex),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+//GetType returns the type of data that this RPC can check
+func (c *cachedRPC) GetType() bl.BlacklistedEntryType {
+	return c.rpc.GetType()
+}
+
+//Check checks a set of indexes against the cache and sends the indexes
+//which are not cached to the wrapped RPC
+func (c *cachedRPC) Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+	c.load(ctx)
+
+	results := make(map[string]database.BlacklistResult)
+	var missing []string
+	queued := make(map[string]bool)
+	now := time.Now()
+
+	c.mutex.Lock()
+	for _, index := range indexes {
+		if cached, ok := c.get(index, now); ok {
+			if cached.Found {
+				results[index] = cached.Result
+			}
+			continue
+		}
+		if !queued[index] {
+			queued[index] = true
+			missing = append(missing, index)
+		}
+	}
+	c.mutex.Unlock()
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+	rpcResults, err := c.rpc.Check(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	newEntries := make([]database.CachedResult, len(missing))
+	c.mutex.Lock()
+	for i, index := range missing {
+		result, found := rpcResults[index]
+		ttl := c.opts.NegativeTTL
+		if found {
+			ttl = c.opts.TTL
+			results[index] = result
+		}
+		newEntries[i] = database.CachedResult{
+			Index:   index,
+			Found:   found,
+			Result:  result,
+			Expires: now.Add(ttl).Unix(),
+		}
+		c.put(newEntries[i], now.Add(ttl))
+	}
+	c.mutex.Unlock()
+
+	if c.opts.Store != nil {
+		err = c.opts.Store.StoreRPCCache(ctx, c.opts.Name, newEntries)
+		if err != nil {
+			c.handleError(err)
+		}
+	}
+	return results, nil
+}
+
+//cacheEntry is the value held by the elements of the LRU list
+type cacheEntry struct {
+	result  database.CachedResult
+	expires time.Time
+}
+
+//get returns the cached result of an index if it has not expired and marks
+//it as recently used. The mutex must be held.
+func (c *cachedRPC) get(index string, now time.Time) (database.CachedResult, bool) {
+	element, ok := c.entries[index]
+	if !ok {
+		return database.CachedResult{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, index)
+		return database.CachedResult{}, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+//put caches a result and evicts the least recently used results if the
+//cache is full. The mutex must be held.
+func (c *cachedRPC) put(result database.CachedResult, expires time.Time) {
+	if element, ok := c.entries[result.Index]; ok {
+		element.Value = &cacheEntry{result: result, expires: expires}
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[result.Index] = c.lru.PushFront(&cacheEntry{result: result, expires: expires})
+	for c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).result.Index)
+	}
+}
+
+//load loads the persisted cache the first time it is called. A failed
+//load is not retried, and the cache starts out empty instead.
+func (c *cachedRPC) load(ctx context.Context) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.loaded || c.opts.Store == nil {
+		return
+	}
+	c.loaded = true
+
+	stored, err := c.opts.Store.LoadRPCCache(ctx, c.opts.Name)
+	if err != nil {
+		c.handleError(err)
+		return
+	}
+	now := time.Now()
+	for _, result := range stored {
+		expires := time.Unix(result.Expires, 0)
+		if now.Before(expires) {
+			c.put(result, expires)
+		}
+	}
+}
+
+func (c *cachedRPC) handleError(err error) {
+	if c.opts.ErrorHandler != nil {
+		c.opts.ErrorHandler(err)
+	}
+}
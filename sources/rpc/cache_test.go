@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//countingRPC blacklists the indexes in hits and records the indexes
+//it was asked to check
+type countingRPC struct {
+	hits    map[string]bool
+	checked []string
+}
+
+func (c *countingRPC) GetType() list.BlacklistedEntryType {
+	return list.BlacklistedURLType
+}
+
+func (c *countingRPC) Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+	c.checked = append(c.checked, indexes...)
+	results := make(map[string]database.BlacklistResult)
+	for _, index := range indexes {
+		if c.hits[index] {
+			results[index] = database.BlacklistResult{Index: index, List: "counting"}
+		}
+	}
+	return results, nil
+}
+
+func TestCachedRPC(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingRPC{hits: map[string]bool{"evil.com/a": true}}
+	cached := NewCachedRPC(inner, CacheOptions{TTL: time.Hour})
+
+	results, err := cached.Check(ctx, "evil.com/a", "good.com/b", "good.com/b")
+	if err != nil || len(results) != 1 || results["evil.com/a"].List != "counting" {
+		t.Fatal("unexpected results from the first check")
+	}
+	if len(inner.checked) != 2 {
+		t.Errorf("expected 2 indexes to be checked, got %v", inner.checked)
+	}
+
+	//both positive and negative results are served from the cache
+	results, err = cached.Check(ctx, "evil.com/a", "good.com/b")
+	if err != nil || len(results) != 1 || len(inner.checked) != 2 {
+		t.Error("cached indexes were checked again")
+	}
+}
+
+func TestCachedRPCExpiry(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingRPC{hits: map[string]bool{"evil.com/a": true}}
+	cached := NewCachedRPC(inner, CacheOptions{TTL: time.Hour, NegativeTTL: time.Nanosecond})
+
+	cached.Check(ctx, "evil.com/a", "good.com/b")
+	time.Sleep(time.Millisecond)
+	cached.Check(ctx, "evil.com/a", "good.com/b")
+	if len(inner.checked) != 3 || inner.checked[2] != "good.com/b" {
+		t.Errorf("only the expired negative result should be checked again, got %v", inner.checked)
+	}
+}
+
+func TestCachedRPCEviction(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingRPC{}
+	cached := NewCachedRPC(inner, CacheOptions{TTL: time.Hour, MaxEntries: 2})
+
+	cached.Check(ctx, "a", "b")
+	cached.Check(ctx, "a")
+	cached.Check(ctx, "c")
+	//b was the least recently used index
+	inner.checked = nil
+	cached.Check(ctx, "a", "b", "c")
+	if len(inner.checked) != 1 || inner.checked[0] != "b" {
+		t.Errorf("expected only b to be evicted, got %v", inner.checked)
+	}
+}
+
+func TestCachedRPCStore(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewMemoryDB()
+	inner := &countingRPC{hits: map[string]bool{"evil.com/a": true}}
+	opts := CacheOptions{
+		TTL:          time.Hour,
+		Store:        db,
+		ErrorHandler: func(err error) { t.Error(err) },
+	}
+	NewCachedRPC(inner, opts).Check(ctx, "evil.com/a", "good.com/b")
+
+	//a new cache picks up the persisted results
+	inner.checked = nil
+	results, err := NewCachedRPC(inner, opts).Check(ctx, "evil.com/a", "good.com/b")
+	if err != nil || len(results) != 1 || len(inner.checked) != 0 {
+		t.Errorf("persisted results were not loaded, checked %v", inner.checked)
+	}
+}
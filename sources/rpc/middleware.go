@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//ErrDegraded is returned by RPCs wrapped with CircuitBreaker while the
+//breaker is open. The wrapped RPC is not called.
+var ErrDegraded = errors.New("rpc degraded: circuit breaker is open")
+
+//Middleware wraps an RPC in order to alter how it is called
+type Middleware func(RPC) RPC
+
+//Chain wraps an RPC with the given middleware. The first middleware is
+//the outermost, e.g. Chain(rpc, CircuitBreaker(...), Retry(...), RateLimit(...))
+//trips the breaker only once the retries are exhausted and spends a
+//rate limit token on every attempt.
+func Chain(rpc RPC, middleware ...Middleware) RPC {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		rpc = middleware[i](rpc)
+	}
+	return rpc
+}
+
+//checkFunc is the signature of RPC.Check
+type checkFunc func(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error)
+
+//wrappedRPC is an RPC whose Check is replaced by a middleware
+type wrappedRPC struct {
+	entryType list.BlacklistedEntryType
+	check     checkFunc
+}
+
+//GetType returns the type of data that this RPC can check
+func (w wrappedRPC) GetType() list.BlacklistedEntryType {
+	return w.entryType
+}
+
+//Check checks a set of indexes against the wrapped rpc
+func (w wrappedRPC) Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+	return w.check(ctx, indexes...)
+}
+
+//RateLimit limits how often the wrapped RPC is called using a token bucket
+//which holds up to burst tokens and refills at ratePerSecond tokens per
+//second. Each call spends one token, and calls wait for a token unless ctx
+//is cancelled first. The RPC is not limited if ratePerSecond is not positive.
+func RateLimit(ratePerSecond float64, burst int) Middleware {
+	if burst < 1 {
+		burst = 1
+	}
+	return func(rpc RPC) RPC {
+		if !(ratePerSecond > 0) {
+			return rpc
+		}
+		bucket := &tokenBucket{
+			mutex:  new(sync.Mutex),
+			rate:   ratePerSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+		return wrappedRPC{
+			entryType: rpc.GetType(),
+			check: func(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+				err := bucket.wait(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return rpc.Check(ctx, indexes...)
+			},
+		}
+	}
+}
+
+//tokenBucket implements the token bucket used by RateLimit
+type tokenBucket struct {
+	mutex  *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+//wait blocks until a token is available and spends it
+func (t *tokenBucket) wait(ctx context.Context) error {
+	for {
+		t.mutex.Lock()
+		now := time.Now()
+		t.tokens += now.Sub(t.last).Seconds() * t.rate
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+		t.last = now
+		if t.tokens >= 1 {
+			t.tokens--
+			t.mutex.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+		t.mutex.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+//Retry calls the wrapped RPC up to attempts times until it succeeds.
+//The delay between attempts starts at baseDelay and doubles after each
+//attempt, up to maxDelay. Errors caused by ctx or an open circuit breaker
+//are not retried.
+func Retry(attempts int, baseDelay, maxDelay time.Duration) Middleware {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(rpc RPC) RPC {
+		return wrappedRPC{
+			entryType: rpc.GetType(),
+			check: func(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+				delay := baseDelay
+				var err error
+				for attempt := 0; attempt < attempts; attempt++ {
+					if attempt > 0 {
+						timer := time.NewTimer(delay)
+						select {
+						case <-timer.C:
+						case <-ctx.Done():
+							timer.Stop()
+							return nil, ctx.Err()
+						}
+						delay *= 2
+						if maxDelay > 0 && delay > maxDelay {
+							delay = maxDelay
+						}
+					}
+
+					var results map[string]database.BlacklistResult
+					results, err = rpc.Check(ctx, indexes...)
+					if err == nil {
+						return results, nil
+					}
+					if ctx.Err() != nil || errors.Is(err, ErrDegraded) {
+						return nil, err
+					}
+				}
+				return nil, err
+			},
+		}
+	}
+}
+
+//CircuitBreaker stops calling the wrapped RPC after failureThreshold
+//consecutive failures. While the breaker is open, calls return ErrDegraded
+//without reaching the RPC. Once cooldown has passed, a single trial call is
+//let through. The breaker closes if it succeeds, and stays open for another
+//cooldown, measured from the trial's failure, if it fails. Calls which were
+//made before the breaker opened don't change its state once they return.
+func CircuitBreaker(failureThreshold int, cooldown time.Duration) Middleware {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return func(rpc RPC) RPC {
+		breaker := &circuitBreaker{
+			mutex:     new(sync.Mutex),
+			threshold: failureThreshold,
+			cooldown:  cooldown,
+		}
+		return wrappedRPC{
+			entryType: rpc.GetType(),
+			check: func(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+				allowed, trial := breaker.allow()
+				if !allowed {
+					return nil, ErrDegraded
+				}
+				results, err := rpc.Check(ctx, indexes...)
+				//cancelled calls say nothing about the health of the RPC
+				if err != nil && ctx.Err() != nil {
+					breaker.release(trial)
+				} else {
+					breaker.record(trial, err == nil)
+				}
+				return results, err
+			},
+		}
+	}
+}
+
+//circuitBreaker holds the state of a CircuitBreaker
+type circuitBreaker struct {
+	mutex     *sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+//open returns true if the breaker stops calls other than the trial call
+func (c *circuitBreaker) open() bool {
+	return c.failures >= c.threshold
+}
+
+//allow returns true if a call may be made, and whether the call is
+//the trial call of an open breaker
+func (c *circuitBreaker) allow() (bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.open() {
+		return true, false
+	}
+	//let a single trial call through once the cooldown has passed
+	if c.trial || time.Now().Before(c.openUntil) {
+		return false, false
+	}
+	c.trial = true
+	return true, true
+}
+
+//release ends a call without recording its outcome
+func (c *circuitBreaker) release(trial bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if trial {
+		c.trial = false
+	}
+}
+
+//record records the outcome of a call
+func (c *circuitBreaker) record(trial bool, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if trial {
+		c.trial = false
+		if success {
+			c.failures = 0
+		} else {
+			//restart the cooldown from the failed trial
+			c.openUntil = time.Now().Add(c.cooldown)
+		}
+		return
+	}
+	//calls made before the breaker opened are ignored
+	if c.open() {
+		return
+	}
+	if success {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.open() {
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+}
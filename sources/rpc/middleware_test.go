@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//failingRPC fails the first failures calls and then succeeds
+type failingRPC struct {
+	failures int
+	calls    int
+}
+
+func (f *failingRPC) GetType() list.BlacklistedEntryType {
+	return list.BlacklistedURLType
+}
+
+func (f *failingRPC) Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("upstream unavailable")
+	}
+	return make(map[string]database.BlacklistResult), nil
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	inner := &failingRPC{failures: 2}
+	_, err := Chain(inner, Retry(3, time.Millisecond, 2*time.Millisecond)).Check(ctx, "a")
+	if err != nil || inner.calls != 3 {
+		t.Errorf("expected success on the third call, got %v after %d calls", err, inner.calls)
+	}
+
+	inner = &failingRPC{failures: 5}
+	_, err = Chain(inner, Retry(3, time.Millisecond, 2*time.Millisecond)).Check(ctx, "a")
+	if err == nil || inner.calls != 3 {
+		t.Errorf("expected failure after 3 calls, got %v after %d calls", err, inner.calls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	limited := Chain(&failingRPC{}, RateLimit(100, 1))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := limited.Check(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the first call spends the burst, the others wait 10ms each
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("calls were not rate limited, took %v", elapsed)
+	}
+
+	//waiting for a token respects ctx
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	limited.Check(ctx, "a")
+	if _, err := limited.Check(cancelled, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRateLimitWithoutRate(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		limited := Chain(&failingRPC{}, RateLimit(rate, 1))
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 3; i++ {
+				limited.Check(context.Background(), "a")
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("calls with a rate of %v did not return", rate)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	inner := &failingRPC{failures: 3}
+	breaker := Chain(inner, CircuitBreaker(2, 10*time.Millisecond))
+
+	breaker.Check(ctx, "a")
+	breaker.Check(ctx, "a")
+	//the breaker is open, so the rpc is skipped
+	if _, err := breaker.Check(ctx, "a"); !errors.Is(err, ErrDegraded) || inner.calls != 2 {
+		t.Fatalf("expected ErrDegraded without a call, got %v after %d calls", err, inner.calls)
+	}
+
+	//the trial call fails and the breaker opens again
+	time.Sleep(15 * time.Millisecond)
+	if _, err := breaker.Check(ctx, "a"); err == nil || errors.Is(err, ErrDegraded) {
+		t.Fatalf("expected the trial call to reach the rpc, got %v", err)
+	}
+	if _, err := breaker.Check(ctx, "a"); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("expected ErrDegraded after a failed trial, got %v", err)
+	}
+
+	//the trial call succeeds and the breaker closes
+	time.Sleep(15 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := breaker.Check(ctx, "a"); err != nil {
+			t.Fatalf("expected the breaker to close, got %v", err)
+		}
+	}
+}
+
+//gatedRPC blocks each call until a result is sent on the gate it takes
+//from gates, in the order the calls are made. Calls without a gate fail.
+type gatedRPC struct {
+	gates   chan chan error
+	started chan struct{}
+}
+
+func (g *gatedRPC) GetType() list.BlacklistedEntryType {
+	return list.BlacklistedURLType
+}
+
+func (g *gatedRPC) Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error) {
+	var gate chan error
+	select {
+	case gate = <-g.gates:
+	default:
+		return nil, errUnexpectedCall
+	}
+	g.started <- struct{}{}
+	err := <-gate
+	if err != nil {
+		return nil, err
+	}
+	return make(map[string]database.BlacklistResult), nil
+}
+
+//errUnexpectedCall is returned by gatedRPC for calls without a gate
+var errUnexpectedCall = errors.New("unexpected call")
+
+//open sends a gate for the next call and returns it
+func (g *gatedRPC) open() chan error {
+	gate := make(chan error, 1)
+	g.gates <- gate
+	return gate
+}
+
+func TestCircuitBreakerTrial(t *testing.T) {
+	ctx := context.Background()
+	inner := &gatedRPC{gates: make(chan chan error, 4), started: make(chan struct{}, 4)}
+	cooldown := 20 * time.Millisecond
+	breaker := Chain(inner, CircuitBreaker(1, cooldown))
+	upstreamErr := errors.New("upstream unavailable")
+	check := func() <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := breaker.Check(ctx, "a")
+			done <- err
+		}()
+		<-inner.started
+		return done
+	}
+
+	//a call is still in flight when another call opens the breaker
+	staleGate := inner.open()
+	staleDone := check()
+	failGate := inner.open()
+	failGate <- upstreamErr
+	<-check()
+
+	//the trial call outlasts the cooldown
+	time.Sleep(cooldown + 5*time.Millisecond)
+	trialGate := inner.open()
+	trialDone := check()
+
+	//the stale call succeeding neither closes the breaker
+	//nor lets a second trial through
+	staleGate <- nil
+	if err := <-staleDone; err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(cooldown + 5*time.Millisecond)
+	if _, err := breaker.Check(ctx, "a"); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("expected ErrDegraded during the trial, got %v", err)
+	}
+
+	//the breaker stays open for a full cooldown after the trial fails
+	trialGate <- upstreamErr
+	if err := <-trialDone; !errors.Is(err, upstreamErr) {
+		t.Fatalf("expected the trial to fail, got %v", err)
+	}
+	if _, err := breaker.Check(ctx, "a"); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("expected ErrDegraded after a failed trial, got %v", err)
+	}
+	time.Sleep(cooldown + 5*time.Millisecond)
+	nextGate := inner.open()
+	nextGate <- nil
+	if err := <-check(); err != nil {
+		t.Fatalf("expected the next trial to close the breaker, got %v", err)
+	}
+	if _, err := breaker.Check(ctx, "a"); !errors.Is(err, errUnexpectedCall) {
+		t.Fatalf("expected the closed breaker to call the rpc, got %v", err)
+	}
+}
@@ -1,16 +1,19 @@
 package rpc
 
 import (
+	"context"
+
 	"github.com/activecm/rita-bl/database"
 	"github.com/activecm/rita-bl/list"
 )
 
 //RPC is a remote procedure call which can be used to check a given index.
-//Note: no caching is done on RPCs inside of rita-blacklist
+//Note: results are not cached unless the RPC is wrapped with NewCachedRPC
 type RPC interface {
 	//GetType returns the type of data that this RPC can check
 	GetType() list.BlacklistedEntryType
 	//Check checks a set of indexes against the rpc and returns a map
-	//of the indexes to their results
-	Check(...string) (map[string]database.BlacklistResult, error)
+	//of the indexes to their results. The check should be abandoned
+	//if ctx is cancelled.
+	Check(ctx context.Context, indexes ...string) (map[string]database.BlacklistResult, error)
 }
@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"io"
 
 	"github.com/google/safebrowsing"
@@ -19,10 +20,10 @@ func (s safeBrowsingURLsRPC) GetType() list.BlacklistedEntryType {
 
 //Check checks a set of indexes against the rpc and returns a map
 //of the indexes to their results
-func (s safeBrowsingURLsRPC) Check(urls ...string) (map[string]database.BlacklistResult, error) {
+func (s safeBrowsingURLsRPC) Check(ctx context.Context, urls ...string) (map[string]database.BlacklistResult, error) {
 	//threats is a 2d array indexed by the index of the urls and then by the
 	//individual results for the url
-	threats, err := s.safebrowser.LookupURLs(urls)
+	threats, err := s.safebrowser.LookupURLsContext(ctx, urls)
 	if err != nil {
 		return nil, err
 	}
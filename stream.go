@@ -0,0 +1,87 @@
+package blacklist
+
+import (
+	"context"
+
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//CheckResult holds the entries found for a single index
+//by CheckEntriesStream
+type CheckResult struct {
+	//Index is the index as it was read from the input channel
+	Index string
+	//Results holds the entries which matched the index
+	Results []database.BlacklistResult
+}
+
+//CheckEntriesStream checks the indexes read from a channel against the
+//blacklist database and sends a CheckResult for each index which matched
+//at least one entry. Indexes are checked in batches of up to the size set
+//with SetCheckBatchSize. A batch is checked as soon as no more indexes are
+//immediately available, so results are not held back waiting for a full
+//batch. The next batch is not read until the results of the previous
+//batch have been received, so at most one batch is held in memory.
+//
+//The returned channel is closed once indexes is closed or ctx is
+//cancelled. Producers should stop sending once ctx is cancelled, since
+//the remaining indexes are not read.
+func (b *Blacklist) CheckEntriesStream(ctx context.Context, opts CheckOptions,
+	entryType list.BlacklistedEntryType, indexes <-chan string) <-chan CheckResult {
+	results := make(chan CheckResult)
+	go func() {
+		defer close(results)
+		for {
+			batch, ok := readCheckBatch(ctx, indexes, b.checkBatchSize)
+			if len(batch) > 0 {
+				found := b.CheckEntriesWithOptionsContext(ctx, opts, entryType, batch...)
+				for _, index := range batch {
+					if len(found[index]) == 0 {
+						continue
+					}
+					select {
+					case results <- CheckResult{Index: index, Results: found[index]}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			if !ok {
+				return
+			}
+		}
+	}()
+	return results
+}
+
+//readCheckBatch blocks until an index is available, then reads indexes
+//until batchSize indexes have been read or none are immediately available.
+//Returns false if indexes was closed or ctx was cancelled.
+func readCheckBatch(ctx context.Context, indexes <-chan string, batchSize int) ([]string, bool) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	var batch []string
+	select {
+	case index, ok := <-indexes:
+		if !ok {
+			return nil, false
+		}
+		batch = append(batch, index)
+	case <-ctx.Done():
+		return nil, false
+	}
+	for len(batch) < batchSize {
+		select {
+		case index, ok := <-indexes:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, index)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
@@ -0,0 +1,702 @@
+package blacklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/activecm/rita-bl/bloom"
+	"github.com/activecm/rita-bl/database"
+	"github.com/activecm/rita-bl/list"
+)
+
+//updateOptions holds the settings of a Blacklist which alter how
+//lists are written to the database
+type updateOptions struct {
+	//incremental refreshes existing lists by applying the changes
+	//since the last fetch rather than staging a complete copy
+	incremental bool
+	//filterRate is the false positive rate of the bloom filters built for
+	//each list. Filters are not built if filterRate is 0.
+	filterRate float64
+}
+
+//UpdateAction describes what an Update did with a list
+type UpdateAction string
+
+const (
+	//ActionSkipped means the list was not fetched since its cache was valid
+	ActionSkipped UpdateAction = "skipped"
+	//ActionCreated means the list was registered with the database and fetched
+	ActionCreated UpdateAction = "created"
+	//ActionRefreshed means the list was already registered and was fetched again
+	ActionRefreshed UpdateAction = "refreshed"
+	//ActionRemoved means the list was no longer loaded and was removed
+	//from the database
+	ActionRemoved UpdateAction = "removed"
+	//ActionUnchanged means the list was fetched, but had not changed since
+	//the last fetch, so only its metadata was updated
+	ActionUnchanged UpdateAction = "unchanged"
+)
+
+//fetchStatus is the outcome of fetching a list
+type fetchStatus int
+
+const (
+	//fetchSucceeded means the list sent its entries
+	fetchSucceeded fetchStatus = iota
+	//fetchFailed means the list sent an error or ctx was cancelled
+	fetchFailed
+	//fetchNotModified means the list sent list.ErrNotModified
+	//instead of its entries
+	fetchNotModified
+)
+
+//ListReport summarizes the changes an Update made to a single list.
+//A list which failed to update reports the action which was attempted
+//along with the errors which occurred.
+type ListReport struct {
+	//Name is the name of the list
+	Name string
+	//Action is what the Update did with the list
+	Action UpdateAction
+	//Fetched is the number of entries the list sent before validation
+	Fetched int
+	//Rejected is the number of fetched entries which failed validation
+	Rejected int
+	//Skipped is the number of records the list reported with
+	//list.CategoryRecord errors and left out
+	Skipped int
+	//Inserted is the number of entries the database accepted
+	Inserted int
+	//Added is the number of entries added to the list. Lists refreshed
+	//without incremental updates report every fetched entry as added.
+	Added int
+	//Removed is the number of entries removed from the list
+	Removed int
+	//Duration is how long the Update spent on the list
+	Duration time.Duration
+	//Errors holds the errors which occurred while updating the list
+	Errors []error
+}
+
+//MarshalJSON encodes a ListReport with lower camel case keys. The Duration
+//is encoded in seconds and the Errors are encoded as strings.
+func (r ListReport) MarshalJSON() ([]byte, error) {
+	errs := make([]string, len(r.Errors))
+	for i, err := range r.Errors {
+		errs[i] = err.Error()
+	}
+	return json.Marshal(struct {
+		Name     string   `json:"name"`
+		Action   string   `json:"action"`
+		Fetched  int      `json:"fetched"`
+		Rejected int      `json:"rejected"`
+		Skipped  int      `json:"skipped"`
+		Inserted int      `json:"inserted"`
+		Added    int      `json:"added"`
+		Removed  int      `json:"removed"`
+		Seconds  float64  `json:"seconds"`
+		Errors   []string `json:"errors"`
+	}{
+		Name:     r.Name,
+		Action:   string(r.Action),
+		Fetched:  r.Fetched,
+		Rejected: r.Rejected,
+		Skipped:  r.Skipped,
+		Inserted: r.Inserted,
+		Added:    r.Added,
+		Removed:  r.Removed,
+		Seconds:  r.Duration.Seconds(),
+		Errors:   errs,
+	})
+}
+
+//fetchAndValidateEntries fetches the entries of a given list and validates
+//them. Errors sent by the list's FetchData are considered fatal for the
+//fetch, except for list.ErrNotModified and errors for skipped records. The
+//returned function blocks until FetchData has returned, records the number
+//of fetched, rejected and skipped entries in report, and returns the outcome
+//of the fetch. It must only be called once the returned entries have
+//been read.
+func fetchAndValidateEntries(ctx context.Context, l list.List, report *ListReport,
+	errorsOut chan<- error) (list.BlacklistedEntryMap, func() fetchStatus) {
+	//FetchData may update the list's Metadata, so it is read beforehand
+	meta := l.GetMetadata()
+	fetchErrors := make(chan error)
+	failed := false
+	notModified := false
+	skipped := 0
+	fetchFinished := make(chan struct{})
+	go func() {
+		for err := range fetchErrors {
+			if errors.Is(err, list.ErrNotModified) {
+				notModified = true
+				continue
+			}
+			var blErr *list.BlacklistError
+			if errors.As(err, &blErr) && blErr.Category == list.CategoryRecord {
+				skipped++
+				errorsOut <- err
+				continue
+			}
+			failed = true
+			errorsOut <- list.WrapError(list.CategoryFetch, meta.Name, "", "", err)
+		}
+		close(fetchFinished)
+	}()
+
+	//fetch the data
+	rawOutput := list.NewBlacklistedEntryMap(meta.Types...)
+	go func() {
+		l.FetchData(ctx, rawOutput, fetchErrors)
+		close(fetchErrors)
+	}()
+
+	//validate the data, counting the entries on either side
+	var fetched, validated int64
+	countedOutput := countEntries(rawOutput, &fetched)
+	validatedOutput := list.ValidateEntries(ctx, countedOutput, errorsOut)
+	validatedOutput = countEntries(validatedOutput, &validated)
+	return validatedOutput, func() fetchStatus {
+		<-fetchFinished
+		report.Fetched = int(atomic.LoadInt64(&fetched))
+		report.Skipped = skipped
+		//entries dropped after cancellation were not rejected
+		if ctx.Err() == nil {
+			report.Rejected = report.Fetched - int(atomic.LoadInt64(&validated))
+		}
+		if failed || ctx.Err() != nil {
+			return fetchFailed
+		}
+		if notModified {
+			return fetchNotModified
+		}
+		return fetchSucceeded
+	}
+}
+
+//countEntries forwards the entries in an entryMap to a new entryMap and
+//adds the number of entries forwarded to count
+func countEntries(entryMap list.BlacklistedEntryMap, count *int64) list.BlacklistedEntryMap {
+	countedOutput := make(list.BlacklistedEntryMap)
+	for entryType, entryChannel := range entryMap {
+		countedChannel := make(chan list.BlacklistedEntry)
+		countedOutput[entryType] = countedChannel
+		go func(in <-chan list.BlacklistedEntry, out chan<- list.BlacklistedEntry) {
+			for entry := range in {
+				atomic.AddInt64(count, 1)
+				out <- entry
+			}
+			close(out)
+		}(entryChannel, countedChannel)
+	}
+	return countedOutput
+}
+
+//dedupeEntries forwards the entries in an entryMap to a new entryMap,
+//dropping entries whose index was already forwarded. Lists such as hosts
+//files may repeat an entry, which the database would otherwise reject.
+func dedupeEntries(entryMap list.BlacklistedEntryMap) list.BlacklistedEntryMap {
+	uniqueOutput := make(list.BlacklistedEntryMap)
+	for entryType, entryChannel := range entryMap {
+		uniqueChannel := make(chan list.BlacklistedEntry)
+		uniqueOutput[entryType] = uniqueChannel
+		go func(in <-chan list.BlacklistedEntry, out chan<- list.BlacklistedEntry) {
+			seen := make(map[string]bool)
+			for entry := range in {
+				if seen[entry.Index] {
+					continue
+				}
+				seen[entry.Index] = true
+				out <- entry
+			}
+			close(out)
+		}(entryChannel, uniqueChannel)
+	}
+	return uniqueOutput
+}
+
+//buildFilters forwards the entries in an entryMap to a new entryMap and
+//records their indexes. The returned function must only be called once
+//the returned entries have been read. It returns an encoded bloom filter
+//of the recorded indexes for each entry type, or nil if filterRate is 0.
+func buildFilters(entryMap list.BlacklistedEntryMap,
+	filterRate float64) (list.BlacklistedEntryMap, func() map[list.BlacklistedEntryType][]byte) {
+	if filterRate <= 0 {
+		return entryMap, func() map[list.BlacklistedEntryType][]byte { return nil }
+	}
+	recordedOutput := make(list.BlacklistedEntryMap)
+	recorded := make(map[list.BlacklistedEntryType]*[]string)
+	wg := new(sync.WaitGroup)
+	for entryType, entryChannel := range entryMap {
+		recordedChannel := make(chan list.BlacklistedEntry)
+		recordedOutput[entryType] = recordedChannel
+		indexes := new([]string)
+		recorded[entryType] = indexes
+		wg.Add(1)
+		go func(in <-chan list.BlacklistedEntry, out chan<- list.BlacklistedEntry) {
+			defer wg.Done()
+			for entry := range in {
+				*indexes = append(*indexes, entry.Index)
+				out <- entry
+			}
+			close(out)
+		}(entryChannel, recordedChannel)
+	}
+	return recordedOutput, func() map[list.BlacklistedEntryType][]byte {
+		wg.Wait()
+		filters := make(map[list.BlacklistedEntryType][]byte, len(recorded))
+		for entryType, indexes := range recorded {
+			filter := bloom.New(len(*indexes), filterRate)
+			for _, index := range *indexes {
+				filter.Add(index)
+			}
+			//MarshalBinary never fails
+			filters[entryType], _ = filter.MarshalBinary()
+		}
+		return filters
+	}
+}
+
+//updateJob updates a single list and reports the changes it made.
+//Errors are sent to errorsOut.
+type updateJob func(errorsOut chan<- error) ListReport
+
+//runUpdateJobs runs the given jobs with at most workers jobs running at once.
+//Each job receives its own error channel, and the errors it sends are
+//recorded in its report as well as forwarded to errorsOut.
+//The reports are returned in the same order as the jobs.
+func runUpdateJobs(jobs []updateJob, workers int, errorsOut chan<- error) []ListReport {
+	if workers < 1 {
+		workers = 1
+	}
+	reports := make([]ListReport, len(jobs))
+	workerSlots := make(chan struct{}, workers)
+	wg := new(sync.WaitGroup)
+	for i, job := range jobs {
+		workerSlots <- struct{}{}
+		wg.Add(1)
+		go func(i int, job updateJob) {
+			defer wg.Done()
+			defer func() { <-workerSlots }()
+
+			listErrors, collectedErrors := collectErrors(errorsOut)
+			start := time.Now()
+			report := job(listErrors)
+			report.Duration = time.Since(start)
+			report.Errors = collectedErrors()
+			reports[i] = report
+		}(i, job)
+	}
+	wg.Wait()
+	return reports
+}
+
+//collectErrors returns a channel which forwards errors to errorsOut.
+//The returned function closes the channel, so it must only be called once
+//every sender has finished, and returns the errors which were forwarded.
+func collectErrors(errorsOut chan<- error) (chan<- error, func() []error) {
+	errorsIn := make(chan error)
+	var collected []error
+	finished := make(chan struct{})
+	go func() {
+		for err := range errorsIn {
+			collected = append(collected, err)
+			errorsOut <- err
+		}
+		close(finished)
+	}()
+	return errorsIn, func() []error {
+		close(errorsIn)
+		<-finished
+		return collected
+	}
+}
+
+//entryWriter writes entries into the database, e.g. Handle.InsertEntries
+type entryWriter func(ctx context.Context, entryType list.BlacklistedEntryType,
+	entries <-chan list.BlacklistedEntry, wg *sync.WaitGroup, errorsOut chan<- error)
+
+//writeEntries writes the entries of a list with the given entryWriter and
+//waits for the writes to finish. Repeated entries are only written once.
+//Errors are reported as database errors. Returns the number of entries
+//the database accepted.
+func writeEntries(ctx context.Context, listName string, entryMap list.BlacklistedEntryMap,
+	write entryWriter, errorsOut chan<- error) int {
+	var written int64
+	wg := new(sync.WaitGroup)
+	for entryType, entryChannel := range entryMap {
+		wg.Add(1)
+		go func(entryType list.BlacklistedEntryType, entries chan list.BlacklistedEntry) {
+			defer wg.Done()
+			typeErrors := make(chan error)
+			forwarded := make(chan struct{})
+			var failed int64
+			go func() {
+				for err := range typeErrors {
+					var writeErr *database.WriteError
+					if errors.As(err, &writeErr) {
+						failed += int64(writeErr.Entries)
+					}
+					errorsOut <- databaseError(listName, entryType, err)
+				}
+				close(forwarded)
+			}()
+			var sent int64
+			uniqueEntries := dedupeEntries(list.BlacklistedEntryMap{entryType: entries})
+			countedEntries := countEntries(uniqueEntries, &sent)
+			writeWG := new(sync.WaitGroup)
+			writeWG.Add(1)
+			write(ctx, entryType, countedEntries[entryType], writeWG, typeErrors)
+			writeWG.Wait()
+			close(typeErrors)
+			<-forwarded
+			atomic.AddInt64(&written, atomic.LoadInt64(&sent)-failed)
+		}(entryType, entryChannel)
+	}
+	wg.Wait()
+	return int(written)
+}
+
+//databaseError wraps an error returned by the database
+func databaseError(listName string, entryType list.BlacklistedEntryType, err error) error {
+	return list.WrapError(list.CategoryDatabase, listName, entryType, "", err)
+}
+
+//updateExistingList refreshes a list which is already registered
+//with the database
+func updateExistingList(ctx context.Context, existingList list.List,
+	dbHandle database.Handle, opts updateOptions, errorsOut chan<- error) ListReport {
+	//fetch the whole list if it needs a filter which it doesn't hold yet
+	if missingFilters(existingList.GetMetadata(), opts) {
+		meta := existingList.GetMetadata()
+		meta.ETag = ""
+		meta.LastModified = ""
+		meta.ContentHash = ""
+		existingList.SetMetadata(meta)
+	}
+
+	var report ListReport
+	var filters map[list.BlacklistedEntryType][]byte
+	var ok bool
+	if opts.incremental {
+		report, filters, ok = refreshListIncrementally(ctx, existingList, dbHandle, opts, errorsOut)
+	} else {
+		report, filters, ok = refreshListStaged(ctx, existingList, dbHandle, opts, errorsOut)
+	}
+	if !ok {
+		return report
+	}
+
+	//the Metadata includes any validators set by FetchData. Unchanged
+	//lists keep the filters built from their entries.
+	meta := existingList.GetMetadata()
+	meta.LastUpdate = time.Now().Unix()
+	if report.Action != ActionUnchanged {
+		meta.FilterVersion = storeFilters(ctx, meta, filters, dbHandle, errorsOut)
+	}
+	err := dbHandle.UpdateListMetadata(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+	}
+	return report
+}
+
+//missingFilters returns true if bloom filters are enabled and the list
+//does not hold any filters
+func missingFilters(meta list.Metadata, opts updateOptions) bool {
+	return opts.filterRate > 0 && meta.FilterVersion == 0
+}
+
+//disableFilters clears the FilterVersion the database holds for a list
+//before its entries change, since lookups screened by the filters built
+//from the old entries would miss the new entries. The new filters are
+//stored once the entries have been written. Returns false if the
+//metadata couldn't be updated.
+func disableFilters(ctx context.Context, meta list.Metadata,
+	dbHandle database.Handle, errorsOut chan<- error) bool {
+	if meta.FilterVersion == 0 {
+		return true
+	}
+	meta.FilterVersion = 0
+	err := dbHandle.UpdateListMetadata(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+		return false
+	}
+	return true
+}
+
+//storeFilters replaces the bloom filters stored for a list with the filters
+//built from its new entries and returns the list's new FilterVersion. The
+//stale filters of a list refreshed without bloom filters are removed.
+//Returns 0 if the list holds no filters or the filters couldn't be stored.
+func storeFilters(ctx context.Context, meta list.Metadata, filters map[list.BlacklistedEntryType][]byte,
+	dbHandle database.Handle, errorsOut chan<- error) int64 {
+	if len(filters) == 0 && meta.FilterVersion == 0 {
+		return 0
+	}
+	err := dbHandle.SetFilters(ctx, meta, filters)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+		return 0
+	}
+	if len(filters) == 0 {
+		return 0
+	}
+	//the version must differ from any version the list held before
+	return time.Now().UnixNano()
+}
+
+//refreshListIncrementally fetches a list and applies the entries which were
+//added and removed since the last fetch. Entries whose extra data changed
+//are counted as both removed and added. Nothing is changed if the fetch
+//fails. Returns the bloom filters of the new entries and true if the list
+//was refreshed.
+func refreshListIncrementally(ctx context.Context, existingList list.List, dbHandle database.Handle,
+	opts updateOptions, errorsOut chan<- error) (ListReport, map[list.BlacklistedEntryType][]byte, bool) {
+	meta := existingList.GetMetadata()
+	report := ListReport{Name: meta.Name, Action: ActionRefreshed}
+
+	//load in the entries held from the last fetch, keeping only the
+	//encoded extra data of each entry to detect changes
+	oldEntries := make(map[list.BlacklistedEntryType]map[string]string)
+	for _, entryType := range meta.Types {
+		entries, err := dbHandle.GetListEntries(ctx, meta, entryType)
+		if err != nil {
+			errorsOut <- databaseError(meta.Name, entryType, err)
+			return report, nil, false
+		}
+		oldEntries[entryType] = make(map[string]string, len(entries))
+		for _, entry := range entries {
+			oldEntries[entryType][entry.Index], _ = encodeExtraData(entry.ExtraData)
+		}
+	}
+
+	//kick off fetching in a new thread
+	entryMap, waitForFetch := fetchAndValidateEntries(ctx, existingList, &report, errorsOut)
+	entryMap, filters := buildFilters(entryMap, opts.filterRate)
+
+	//find the changes for each entry type. The channels must be
+	//read concurrently since lists may send the types in any order.
+	added := make(map[list.BlacklistedEntryType][]list.BlacklistedEntry)
+	removed := make(map[list.BlacklistedEntryType][]string)
+	mutex := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	for entryType, entryChannel := range entryMap {
+		wg.Add(1)
+		go func(entryType list.BlacklistedEntryType, entries <-chan list.BlacklistedEntry) {
+			defer wg.Done()
+			var typeAdded []list.BlacklistedEntry
+			var typeRemoved []string
+			seen := make(map[string]bool)
+			for entry := range entries {
+				if seen[entry.Index] {
+					continue
+				}
+				seen[entry.Index] = true
+				oldData, ok := oldEntries[entryType][entry.Index]
+				if !ok {
+					typeAdded = append(typeAdded, entry)
+					continue
+				}
+				//entries whose extra data changed are replaced
+				newData, encoded := encodeExtraData(entry.ExtraData)
+				if !encoded || oldData != newData {
+					typeAdded = append(typeAdded, entry)
+					typeRemoved = append(typeRemoved, entry.Index)
+				}
+			}
+			for index := range oldEntries[entryType] {
+				if !seen[index] {
+					typeRemoved = append(typeRemoved, index)
+				}
+			}
+			mutex.Lock()
+			added[entryType] = typeAdded
+			removed[entryType] = typeRemoved
+			mutex.Unlock()
+		}(entryType, entryChannel)
+	}
+	wg.Wait()
+
+	//keep the old entries if the fetch failed. The list will be
+	//fetched again on the next update since LastUpdate is unchanged.
+	switch waitForFetch() {
+	case fetchFailed:
+		return report, nil, false
+	case fetchNotModified:
+		report.Action = ActionUnchanged
+		return report, nil, true
+	}
+
+	changed := false
+	for _, entryType := range meta.Types {
+		if len(added[entryType]) != 0 || len(removed[entryType]) != 0 {
+			changed = true
+		}
+	}
+	if changed && !disableFilters(ctx, meta, dbHandle, errorsOut) {
+		return report, nil, false
+	}
+
+	ok := true
+	for _, entryType := range meta.Types {
+		if len(added[entryType]) == 0 && len(removed[entryType]) == 0 {
+			continue
+		}
+		err := dbHandle.UpdateEntries(ctx, meta, entryType, added[entryType], removed[entryType])
+		if err != nil {
+			errorsOut <- databaseError(meta.Name, entryType, err)
+			ok = false
+			continue
+		}
+		report.Inserted += len(added[entryType])
+		report.Added += len(added[entryType])
+		report.Removed += len(removed[entryType])
+	}
+	if !ok {
+		return report, nil, false
+	}
+	return report, filters(), true
+}
+
+//encodeExtraData encodes the extra data of an entry so that extra data read
+//back from the database compares equal to freshly fetched extra data, e.g.
+//int64 and float64 values which hold the same number. Empty extra data is
+//encoded as an empty string. Returns false if the extra data can't be encoded.
+func encodeExtraData(extraData map[string]interface{}) (string, bool) {
+	if len(extraData) == 0 {
+		return "", true
+	}
+	encoded, err := json.Marshal(extraData)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+//refreshListStaged fetches a list into the staging area and swaps it in
+//once the fetch finishes. The old entries are kept if the fetch fails.
+//Returns the bloom filters of the new entries and true if the list
+//was refreshed.
+func refreshListStaged(ctx context.Context, existingList list.List, dbHandle database.Handle,
+	opts updateOptions, errorsOut chan<- error) (ListReport, map[list.BlacklistedEntryType][]byte, bool) {
+	meta := existingList.GetMetadata()
+	report := ListReport{Name: meta.Name, Action: ActionRefreshed}
+
+	//remove any entries left over from an interrupted refresh
+	err := dbHandle.DiscardStagedEntries(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+		return report, nil, false
+	}
+
+	//kick off fetching in a new thread
+	entryMap, waitForFetch := fetchAndValidateEntries(ctx, existingList, &report, errorsOut)
+	entryMap, filters := buildFilters(entryMap, opts.filterRate)
+
+	//write the new entries to the staging area so readers
+	//continue to see the old entries during the refresh
+	//StageEntries only finishes if fetchAndValidateEntries finishes
+	staged := writeEntries(ctx, meta.Name, entryMap, dbHandle.StageEntries, errorsOut)
+
+	//keep the old entries if the fetch failed. The list will be
+	//fetched again on the next update since LastUpdate is unchanged.
+	//Staged entries left behind by a cancelled refresh are discarded
+	//when the list is refreshed again.
+	switch waitForFetch() {
+	case fetchFailed:
+		if ctx.Err() == nil {
+			err = dbHandle.DiscardStagedEntries(ctx, meta)
+			if err != nil {
+				errorsOut <- databaseError(meta.Name, "", err)
+			}
+		}
+		return report, nil, false
+	case fetchNotModified:
+		report.Action = ActionUnchanged
+		return report, nil, true
+	}
+
+	//swap the new entries in
+	if !disableFilters(ctx, meta, dbHandle, errorsOut) {
+		return report, nil, false
+	}
+	err = dbHandle.CommitStagedEntries(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+		return report, nil, false
+	}
+	report.Inserted = staged
+	report.Added = staged
+	return report, filters(), true
+}
+
+//createNewList registers a new list with the database and inserts
+//its entries
+func createNewList(ctx context.Context, listToAdd list.List,
+	dbHandle database.Handle, opts updateOptions, errorsOut chan<- error) ListReport {
+	meta := listToAdd.GetMetadata()
+	report := ListReport{Name: meta.Name, Action: ActionCreated}
+
+	//register the list, create, and index the new collections
+	//set the cache to invalid so if the code errors,
+	//the code will reimport it
+	preWriteMetaCopy := meta
+	preWriteMetaCopy.LastUpdate = 0
+	preWriteMetaCopy.CacheTime = 0
+	err := dbHandle.RegisterList(ctx, preWriteMetaCopy)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+		return report
+	}
+
+	//kick off fetching in a new thread
+	entryMap, waitForFetch := fetchAndValidateEntries(ctx, listToAdd, &report, errorsOut)
+	entryMap, filters := buildFilters(entryMap, opts.filterRate)
+
+	//InsertEntries only finishes if fetchAndValidateEntries finishes
+	inserted := writeEntries(ctx, meta.Name, entryMap, dbHandle.InsertEntries, errorsOut)
+	report.Inserted = inserted
+	report.Added = inserted
+
+	//leave the cache invalid if the fetch failed. A new list holds no
+	//validators, so a fetch which reports the list is unchanged is
+	//treated as a failure too.
+	if waitForFetch() != fetchSucceeded {
+		return report
+	}
+
+	//set the cache to valid, including any validators set by FetchData
+	meta = listToAdd.GetMetadata()
+	meta.LastUpdate = time.Now().Unix()
+	meta.FilterVersion = storeFilters(ctx, meta, filters(), dbHandle, errorsOut)
+	err = dbHandle.UpdateListMetadata(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+	}
+	return report
+}
+
+//removeList removes a list which is no longer loaded from the database
+func removeList(ctx context.Context, meta list.Metadata,
+	dbHandle database.Handle, errorsOut chan<- error) ListReport {
+	report := ListReport{Name: meta.Name, Action: ActionRemoved}
+	err := dbHandle.RemoveList(ctx, meta)
+	if err != nil {
+		errorsOut <- databaseError(meta.Name, "", err)
+	}
+	return report
+}
+
+//skipList reports a list which was not fetched since its cache is valid
+func skipList(meta list.Metadata) updateJob {
+	return func(errorsOut chan<- error) ListReport {
+		return ListReport{Name: meta.Name, Action: ActionSkipped}
+	}
+}